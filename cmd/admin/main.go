@@ -0,0 +1,321 @@
+// Command admin runs administrative tasks against the API's database: creating users,
+// resetting passwords, unlocking accounts, purging expired tokens, running migrations and
+// seeding the book catalog. Every command writes a single JSON object to standard output, so that its
+// result can be consumed by scripts; logs go to standard error.
+//
+// Usage:
+//
+//	admin [-dsn DSN] <command> <subcommand> [flags]
+//
+// The DSN defaults to the DSN environment variable, as it does for the API.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+	"github.com/polyglotdev/vue-api/internal/driver"
+	"github.com/polyglotdev/vue-api/internal/migrations"
+)
+
+// result is the JSON object every command writes to standard output.
+type result struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+// command is one runnable subcommand, such as "user create".
+type command struct {
+	usage string
+	run   func(cli *cli, args []string) (any, error)
+}
+
+// commands lists every subcommand, keyed by "command subcommand".
+var commands = map[string]command{
+	"user create":         {"user create -email EMAIL [-first-name NAME] [-last-name NAME] [-admin] [-password PASSWORD]", userCreate},
+	"user reset-password": {"user reset-password -email EMAIL [-password PASSWORD]", userResetPassword},
+	"user unlock":         {"user unlock -email EMAIL", userUnlock},
+	"token purge-expired": {"token purge-expired", tokenPurgeExpired},
+	"migrate up":          {"migrate up", migrateUp},
+	"migrate down":        {"migrate down [-steps N]", migrateDown},
+	"migrate status":      {"migrate status", migrateStatus},
+	"seed books":          {"seed books", seedBooks},
+}
+
+// cli holds what the commands share: the database connection and the models built on it.
+type cli struct {
+	db     *driver.DB
+	models data.Models
+	stdin  io.Reader
+}
+
+func main() {
+	dsn := flag.String("dsn", os.Getenv("DSN"), "database connection string")
+	flag.Usage = usage
+	flag.Parse()
+
+	os.Exit(run(*dsn, flag.Args(), os.Stdin, os.Stdout))
+}
+
+// run dispatches to the named command, writes its result as JSON, and returns the exit code.
+func run(dsn string, args []string, stdin io.Reader, stdout io.Writer) int {
+	if len(args) < 2 {
+		usage()
+		return 2
+	}
+
+	cmd, ok := commands[args[0]+" "+args[1]]
+	if !ok {
+		usage()
+		return 2
+	}
+
+	out, err := execute(dsn, cmd, args[2:], stdin)
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "\t")
+
+	if err != nil {
+		_ = enc.Encode(result{OK: false, Error: err.Error()})
+		return 1
+	}
+
+	_ = enc.Encode(result{OK: true, Result: out})
+	return 0
+}
+
+// execute connects to the database and runs a command.
+func execute(dsn string, cmd command, args []string, stdin io.Reader) (any, error) {
+	if dsn == "" {
+		return nil, errors.New("no database connection string; set DSN or pass -dsn")
+	}
+
+	db, err := driver.ConnectPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.SQL.Close()
+
+	c := &cli{db: db, models: data.New(db.SQL), stdin: stdin}
+
+	return cmd.run(c, args)
+}
+
+// usage prints the list of commands to standard error.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: admin [-dsn DSN] <command> <subcommand> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+
+	for _, name := range []string{
+		"user create", "user reset-password", "token purge-expired",
+		"migrate up", "migrate down", "migrate status", "seed books",
+	} {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+// readPassword returns the password flag if it was given, and otherwise reads the first
+// line of standard input, so that passwords need not appear in the process list.
+func (c *cli) readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	password = strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("no password given; pass -password or write it to standard input")
+	}
+
+	return password, nil
+}
+
+// userCreate creates a verified user; the user is an administrator only if -admin is given.
+func userCreate(c *cli, args []string) (any, error) {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	email := fs.String("email", "", "email address of the new user")
+	firstName := fs.String("first-name", "", "first name of the new user")
+	lastName := fs.String("last-name", "", "last name of the new user")
+	admin := fs.Bool("admin", false, "make the new user an administrator")
+	password := fs.String("password", "", "password of the new user; read from standard input if omitted")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *email == "" {
+		return nil, errors.New("-email is required")
+	}
+
+	pw, err := c.readPassword(*password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	id, err := c.models.User.Insert(data.User{
+		Email:     strings.ToLower(strings.TrimSpace(*email)),
+		FirstName: *firstName,
+		LastName:  *lastName,
+		Password:  pw,
+		IsAdmin:   *admin,
+		// the administrator vouches for the address, so no verification email is needed
+		VerifiedAt: &now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"id": id, "email": *email, "is_admin": *admin}, nil
+}
+
+// userResetPassword sets a new password for a user and signs them out everywhere.
+func userResetPassword(c *cli, args []string) (any, error) {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	email := fs.String("email", "", "email address of the user")
+	password := fs.String("password", "", "the new password; read from standard input if omitted")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	user, err := c.models.User.GetByEmail(strings.ToLower(strings.TrimSpace(*email)))
+	if err != nil {
+		return nil, fmt.Errorf("no user with email %q: %w", *email, err)
+	}
+
+	pw, err := c.readPassword(*password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.ResetPassword(pw)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.models.Token.DeleteByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"id": user.ID, "email": user.Email}, nil
+}
+
+// userUnlock clears the failed sign ins counted against an account, unlocking it. It is
+// the way back in for an administrator who is locked out of the API.
+func userUnlock(c *cli, args []string) (any, error) {
+	fs := flag.NewFlagSet("user unlock", flag.ContinueOnError)
+	email := fs.String("email", "", "email address of the user")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	user, err := c.models.User.GetByEmail(strings.ToLower(strings.TrimSpace(*email)))
+	if err != nil {
+		return nil, fmt.Errorf("no user with email %q: %w", *email, err)
+	}
+
+	cleared, err := c.models.LoginAttempt.Clear(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"id": user.ID, "email": user.Email, "cleared_failures": cleared}, nil
+}
+
+// tokenPurgeExpired deletes every expired token.
+func tokenPurgeExpired(c *cli, args []string) (any, error) {
+	deleted, err := c.models.Token.DeleteExpired()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"deleted": deleted}, nil
+}
+
+// migrator returns a Migrator that logs to standard error.
+func (c *cli) migrator() (*migrations.Migrator, error) {
+	m, err := migrations.New(c.db.SQL)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Logf = func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+
+	return m, nil
+}
+
+// migrationNames lists migrations as "NNNN_name" strings.
+func migrationNames(ms []migrations.Migration) []string {
+	names := make([]string, 0, len(ms))
+	for _, m := range ms {
+		names = append(names, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+	}
+
+	return names
+}
+
+// migrateUp applies every pending migration.
+func migrateUp(c *cli, args []string) (any, error) {
+	m, err := c.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.Up(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"applied": migrationNames(applied)}, nil
+}
+
+// migrateDown reverts the most recent migrations.
+func migrateDown(c *cli, args []string) (any, error) {
+	fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *steps < 1 {
+		return nil, errors.New("-steps must be at least 1")
+	}
+
+	m, err := c.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	reverted, err := m.Down(context.Background(), *steps)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"reverted": migrationNames(reverted)}, nil
+}
+
+// migrateStatus lists every migration and when it was applied.
+func migrateStatus(c *cli, args []string) (any, error) {
+	m, err := c.migrator()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Status(context.Background())
+}
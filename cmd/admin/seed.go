@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// seedBook is one book in the sample catalog.
+type seedBook struct {
+	title       string
+	author      string
+	year        int
+	genres      []string
+	description string
+}
+
+// seedCatalog is a small sample catalog, enough to develop the Vue frontend against.
+var seedCatalog = []seedBook{
+	{"Pride and Prejudice", "Jane Austen", 1813, []string{"Classic", "Romance"},
+		"The turbulent relationship between Elizabeth Bennet and Fitzwilliam Darcy."},
+	{"Emma", "Jane Austen", 1815, []string{"Classic", "Romance"},
+		"A well-meaning but meddlesome young woman plays matchmaker in her village."},
+	{"Frankenstein", "Mary Shelley", 1818, []string{"Classic", "Horror", "Science Fiction"},
+		"A young scientist creates a sapient creature in an unorthodox experiment."},
+	{"The Time Machine", "H. G. Wells", 1895, []string{"Classic", "Science Fiction"},
+		"A Victorian inventor travels to the year 802,701 and beyond."},
+	{"The War of the Worlds", "H. G. Wells", 1898, []string{"Classic", "Science Fiction"},
+		"Martians invade southern England, and humanity is powerless to stop them."},
+	{"Dracula", "Bram Stoker", 1897, []string{"Classic", "Horror"},
+		"Count Dracula moves from Transylvania to England in search of new blood."},
+	{"The Hound of the Baskervilles", "Arthur Conan Doyle", 1902, []string{"Classic", "Mystery"},
+		"Sherlock Holmes investigates a legendary, supernatural hound on Dartmoor."},
+	{"A Study in Scarlet", "Arthur Conan Doyle", 1887, []string{"Classic", "Mystery"},
+		"The first meeting of Sherlock Holmes and Dr. Watson, and their first case."},
+}
+
+// seedBooks loads the sample catalog. Authors, genres and books that already exist, by
+// name or slug, are left alone, so the command may be run repeatedly.
+func seedBooks(c *cli, args []string) (any, error) {
+	authorIDs, err := existingAuthors(c)
+	if err != nil {
+		return nil, err
+	}
+
+	genreIDs, err := existingGenres(c)
+	if err != nil {
+		return nil, err
+	}
+
+	created := map[string]int{"authors": 0, "genres": 0, "books": 0}
+
+	for _, sb := range seedCatalog {
+		authorID, ok := authorIDs[sb.author]
+		if !ok {
+			authorID, err = c.models.Author.Insert(data.Author{AuthorName: sb.author})
+			if err != nil {
+				return nil, err
+			}
+			authorIDs[sb.author] = authorID
+			created["authors"]++
+		}
+
+		var bookGenres []int
+		for _, name := range sb.genres {
+			genreID, ok := genreIDs[name]
+			if !ok {
+				genreID, err = c.models.Genre.Insert(data.Genre{GenreName: name})
+				if err != nil {
+					return nil, err
+				}
+				genreIDs[name] = genreID
+				created["genres"]++
+			}
+			bookGenres = append(bookGenres, genreID)
+		}
+
+		_, err = c.models.Book.GetOneBySlug(data.Slugify(sb.title))
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		_, _, err = c.models.Book.Insert(data.Book{
+			Title:           sb.title,
+			AuthorID:        authorID,
+			PublicationYear: sb.year,
+			Description:     sb.description,
+		}, bookGenres)
+		if err != nil {
+			return nil, err
+		}
+		created["books"]++
+	}
+
+	return map[string]any{"created": created}, nil
+}
+
+// existingAuthors maps the name of every author in the database to their id.
+func existingAuthors(c *cli) (map[string]int, error) {
+	authors, err := c.models.Author.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]int, len(authors))
+	for _, a := range authors {
+		ids[a.AuthorName] = a.ID
+	}
+
+	return ids, nil
+}
+
+// existingGenres maps the name of every genre in the database to its id.
+func existingGenres(c *cli) (map[string]int, error) {
+	genres, err := c.models.Genre.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]int, len(genres))
+	for _, g := range genres {
+		ids[g.GenreName] = g.ID
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// readBookFilters reads the pagination, sorting and filtering options for a list of books
+// from the query string, recording any problems in v.
+//
+// It accepts the following query string parameters:
+//   - page: The page to return, starting from 1. Defaults to 1.
+//   - page_size: The number of books per page, up to 100. Defaults to 20.
+//   - sort: The column to sort by, optionally prefixed with "-" for descending order.
+//     One of id, title, publication_year or created_at. Defaults to title.
+//   - q: An optional search term, matched against the title.
+//   - author_id: Only return books by this author.
+//   - genre_ids: A comma separated list; only return books in at least one of these genres.
+//   - year_from, year_to: Only return books published in this range of years, inclusive.
+func (app *application) readBookFilters(qs url.Values, v *validator) data.BookFilters {
+	filters := data.BookFilters{
+		Filters: data.Filters{
+			Page:         app.readInt(qs, "page", 1, v),
+			PageSize:     app.readInt(qs, "page_size", 20, v),
+			Sort:         app.readString(qs, "sort", "title"),
+			SortSafelist: data.BookSortSafelist,
+			Query:        strings.TrimSpace(qs.Get("q")),
+		},
+		AuthorID: app.readInt(qs, "author_id", 0, v),
+		GenreIDs: app.readCSVInts(qs, "genre_ids", v),
+		YearFrom: app.readInt(qs, "year_from", 0, v),
+		YearTo:   app.readInt(qs, "year_to", 0, v),
+	}
+
+	validateFilters(v, filters.Filters)
+	v.Check(filters.AuthorID >= 0, "author_id", "must not be negative")
+	v.Check(filters.YearFrom >= 0, "year_from", "must not be negative")
+	v.Check(filters.YearTo >= 0, "year_to", "must not be negative")
+	v.Check(filters.YearTo == 0 || filters.YearFrom <= filters.YearTo, "year_to", "must not be before year_from")
+	v.Check(len(filters.GenreIDs) <= 50, "genre_ids", "must not contain more than 50 genres")
+
+	return filters
+}
+
+// listBooks writes one page of books matching filters, in the standard envelope.
+func (app *application) listBooks(w http.ResponseWriter, filters data.BookFilters) {
+	books, metadata, err := app.models.Book.GetAll(filters)
+	if err != nil {
+		app.errorLog.Println("error fetching books:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Books retrieved",
+		Data:    envelope{"books": books, "metadata": metadata},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// AllBooks is the handler used to list books, one page at a time. See readBookFilters
+// for the query string parameters it accepts.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) AllBooks(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+
+	filters := app.readBookFilters(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	app.listBooks(w, filters)
+}
+
+// AuthorBooks is the handler used to list the books by one author, named by the "id" URL
+// parameter. It accepts the same query string parameters as AllBooks, apart from author_id.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) AuthorBooks(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	_, err = app.models.Author.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJson(w, errors.New("author not found"), http.StatusNotFound)
+			return
+		}
+
+		app.errorLog.Println("error fetching author:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	v := newValidator()
+
+	filters := app.readBookFilters(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	filters.AuthorID = id
+	app.listBooks(w, filters)
+}
+
+// GenreBooks is the handler used to list the books in one genre, named by the "id" URL
+// parameter. It accepts the same query string parameters as AllBooks, apart from genre_ids.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) GenreBooks(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	_, err = app.models.Genre.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJson(w, errors.New("genre not found"), http.StatusNotFound)
+			return
+		}
+
+		app.errorLog.Println("error fetching genre:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	v := newValidator()
+
+	filters := app.readBookFilters(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	filters.GenreIDs = []int{id}
+	app.listBooks(w, filters)
+}
+
+// OneBook is the handler used to fetch a single book, with its author and genres, by the
+// "slug" URL parameter.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) OneBook(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+
+	book, err := app.models.Book.GetOneBySlug(slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJson(w, errors.New("book not found"), http.StatusNotFound)
+			return
+		}
+
+		app.errorLog.Println("error fetching book:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Book retrieved",
+		Data:    envelope{"book": book},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// bookRequest is the JSON body accepted when creating or updating a book.
+type bookRequest struct {
+	Title           string `json:"title"`
+	AuthorID        int    `json:"author_id"`
+	PublicationYear int    `json:"publication_year"`
+	Description     string `json:"description"`
+	GenreIDs        []int  `json:"genre_ids"`
+}
+
+// validateBookRequest checks a book request, including that its author exists. Duplicate
+// genre ids are removed. Genre ids that do not exist are caught by the books_genres foreign
+// key when the book is saved.
+func (app *application) validateBookRequest(v *validator, input *bookRequest) error {
+	input.Title = strings.TrimSpace(input.Title)
+
+	v.Check(notBlank(input.Title), "title", "must be provided")
+	v.Check(len(input.Title) <= 512, "title", "must not be more than 512 characters long")
+	v.Check(input.PublicationYear >= 0, "publication_year", "must not be negative")
+	v.Check(input.PublicationYear <= time.Now().Year()+1, "publication_year", "must not be in the future")
+	v.Check(len(input.GenreIDs) <= 50, "genre_ids", "must not contain more than 50 genres")
+
+	seen := make(map[int]bool)
+	genreIDs := input.GenreIDs[:0]
+	for _, id := range input.GenreIDs {
+		v.Check(id > 0, "genre_ids", "must only contain positive ids")
+		if !seen[id] {
+			seen[id] = true
+			genreIDs = append(genreIDs, id)
+		}
+	}
+	input.GenreIDs = genreIDs
+
+	v.Check(input.AuthorID > 0, "author_id", "must be provided")
+	if input.AuthorID > 0 {
+		_, err := app.models.Primary().Author.GetOne(input.AuthorID)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			v.Check(false, "author_id", "does not exist")
+		}
+	}
+
+	return nil
+}
+
+// CreateBook is the handler used by administrators to add a book. A unique slug is
+// generated from the title, and the book and its genres are saved in one transaction.
+//
+// It expects a JSON object with the following fields:
+//   - title: The title of the book.
+//   - author_id: The id of the book's author, which must exist.
+//   - publication_year: The year the book was published.
+//   - description: A description of the book.
+//   - genre_ids: The ids of the genres the book belongs to.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) CreateBook(w http.ResponseWriter, r *http.Request) {
+	var input bookRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	v := newValidator()
+	err = app.validateBookRequest(v, &input)
+	if err != nil {
+		app.errorLog.Println("error validating book:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	id, _, err := app.models.Book.Insert(data.Book{
+		Title:           input.Title,
+		AuthorID:        input.AuthorID,
+		PublicationYear: input.PublicationYear,
+		Description:     input.Description,
+	}, input.GenreIDs)
+	if err != nil {
+		app.errorLog.Println("error inserting book:", err)
+		app.errorJson(w, err)
+		return
+	}
+
+	book, err := app.models.Primary().Book.GetOne(id)
+	if err != nil {
+		app.errorLog.Println("error fetching book:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Book created",
+		Data:    envelope{"book": book},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// fetchBookByID looks up the book named by the "id" URL parameter, writing an error
+// response and returning nil if the id is invalid or no such book exists. It is only used
+// by handlers that change the book, so the book is read from the primary database.
+func (app *application) fetchBookByID(w http.ResponseWriter, r *http.Request) *data.Book {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJson(w, err)
+		return nil
+	}
+
+	book, err := app.models.Primary().Book.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJson(w, errors.New("book not found"), http.StatusNotFound)
+			return nil
+		}
+
+		app.errorLog.Println("error fetching book:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return nil
+	}
+
+	return book
+}
+
+// UpdateBook is the handler used by administrators to change a book and its genres,
+// which are saved in one transaction. It expects the same JSON object as CreateBook.
+// If the title changes, the book gets a new slug.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) UpdateBook(w http.ResponseWriter, r *http.Request) {
+	book := app.fetchBookByID(w, r)
+	if book == nil {
+		return
+	}
+
+	var input bookRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	v := newValidator()
+	err = app.validateBookRequest(v, &input)
+	if err != nil {
+		app.errorLog.Println("error validating book:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	book.Title = input.Title
+	book.AuthorID = input.AuthorID
+	book.PublicationYear = input.PublicationYear
+	book.Description = input.Description
+
+	err = book.Update(input.GenreIDs)
+	if err != nil {
+		app.errorLog.Println("error updating book:", err)
+		app.errorJson(w, err)
+		return
+	}
+
+	book, err = app.models.Primary().Book.GetOne(book.ID)
+	if err != nil {
+		app.errorLog.Println("error fetching book:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Book updated",
+		Data:    envelope{"book": book},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// DeleteBook is the handler used by administrators to delete a book, along with its cover.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) DeleteBook(w http.ResponseWriter, r *http.Request) {
+	book := app.fetchBookByID(w, r)
+	if book == nil {
+		return
+	}
+
+	err := book.Delete()
+	if err != nil {
+		app.errorLog.Println("error deleting book:", err)
+		app.errorJson(w, err)
+		return
+	}
+
+	if book.CoverPath != "" {
+		app.deleteCover(r, book.CoverPath)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Book deleted",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config is the type for all application configuration. Every setting can be given, in
+// increasing order of precedence, by a default, a configuration file, an environment
+// variable or a command line flag; see loadConfig.
+type config struct {
+	env          string        // "development", "staging" or "production"
+	port         int           // what port do we want the web server to listen on
+	logLevel     string        // "info" logs everything; "error" logs only errors
+	corsOrigins  []string      // the origins browsers may call the API from
+	maxSessions  int           // how many concurrent sessions a user may hold; the oldest is evicted beyond this
+	accessTTL    time.Duration // how long an authentication token is valid for
+	refreshTTL   time.Duration // how long a refresh token is valid for
+	idleTimeout  time.Duration // if non-zero, authentication tokens also expire after this long unused
+	coverDir     string        // the directory uploaded book covers are stored in
+	maxCoverSize int64         // the largest cover image we accept, in bytes
+	searchFuzzy  bool          // fall back to typo tolerant trigram search; requires pg_trgm
+	migrate      bool          // apply pending database migrations before serving
+	drainTimeout time.Duration // how long shutdown waits for in-flight requests to finish
+	frontendURL  string        // the base URL of the front end, which links in emails point to
+	resetTTL     time.Duration // how long a password reset link is valid for
+	signingKey   string        // the key signing links sent by email; required outside development
+	publicURL    string        // the base URL the API is reached at, which some links in emails point to
+
+	login struct {
+		window          time.Duration // how far back failed sign ins are counted
+		delayAfter      int           // failures for one account before each attempt is delayed
+		ipDelayAfter    int           // failures from one address before each attempt is delayed
+		baseDelay       time.Duration // the first delay; it doubles with each further failure
+		maxDelay        time.Duration // the longest delay
+		lockoutAfter    int           // failures for one account that lock it
+		lockoutDuration time.Duration // how long a locked account stays locked after the last failure
+	}
+
+	password struct {
+		minLength    int  // the fewest characters a password may have
+		maxLength    int  // the most bytes a password may have; bcrypt allows at most 72
+		rejectCommon bool // whether passwords on the embedded common password list are refused
+	}
+
+	registration struct {
+		enabled bool // whether anyone may create an account with POST /users/register
+	}
+
+	verification struct {
+		required       bool          // refuse to sign in users who have not verified their email address
+		ttl            time.Duration // how long an email verification link is valid for
+		resendInterval time.Duration // the shortest time between two verification emails to one user
+	}
+
+	http struct {
+		readTimeout  time.Duration // how long a client may take to send a whole request
+		writeTimeout time.Duration // how long a handler may take to write its response
+		idleTimeout  time.Duration // how long an idle keep-alive connection is kept open
+	}
+
+	db struct {
+		dsn             string        // the Postgres connection string
+		replicaDSNs     []string      // connection strings of read replicas, if any
+		healthCheck     time.Duration // how often replicas are checked, so reads fail over when one is down
+		maxOpenConns    int           // the most connections the pool opens at once
+		maxIdleConns    int           // the most idle connections the pool keeps
+		connMaxLifetime time.Duration // how long a connection is reused before being replaced
+		connMaxIdleTime time.Duration // how long an idle connection is kept before being closed
+		stmtTimeout     time.Duration // if non-zero, the server cancels statements that run longer
+		connectTimeout  time.Duration // how long to keep trying to reach the database on startup
+		connectBackoff  time.Duration // the wait after the first failed attempt; it doubles after each
+		maxBackoff      time.Duration // the longest wait between attempts
+	}
+
+	mail struct {
+		host     string // the SMTP server to send mail through
+		port     int    // the port of the SMTP server
+		username string // the SMTP user name, if the server requires authentication
+		password string // the SMTP password
+		sender   string // the From address of outgoing mail
+		workers  int    // how many messages are delivered at once
+		attempts int    // how many times delivering a message is tried before giving up
+	}
+}
+
+// secretSettings lists the settings whose values must never be printed in full.
+var secretSettings = map[string]bool{
+	"dsn":                true,
+	"db.replica-dsns":    true,
+	"tokens.signing-key": true,
+	"mail.password":      true,
+}
+
+// environments lists the values the env setting may take.
+var environments = []string{"development", "staging", "production"}
+
+// logLevels lists the values the log-level setting may take.
+var logLevels = []string{"info", "error"}
+
+// stringList is a flag.Value holding a comma separated list. Setting it replaces the
+// whole list, so that a later source overrides an earlier one rather than adding to it.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Get() any { return []string(*l) }
+
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
+
+// flagSet returns a flag set with one flag for every setting, bound to the fields of cfg
+// and with the defaults already stored in them. Flag names double as the keys used in
+// configuration files, where the part before a dot names the section.
+func (cfg *config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+
+	fs.String("config", "", "path to a YAML or TOML configuration file (env CONFIG)")
+	fs.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit; the output is for reading, not for use as a configuration file")
+
+	fs.StringVar(&cfg.env, "env", "development", "environment: development, staging or production")
+	fs.IntVar(&cfg.port, "port", 8081, "port to listen on")
+	fs.StringVar(&cfg.logLevel, "log-level", "info", "log level: info or error")
+	fs.BoolVar(&cfg.migrate, "migrate", false, "apply pending database migrations on startup")
+	fs.StringVar(&cfg.publicURL, "public-url", "http://localhost:8081", "base URL the API is reached at, used in links sent by email")
+	fs.StringVar(&cfg.frontendURL, "frontend-url", "http://localhost:8080", "base URL of the front end, used in links sent by email")
+
+	cfg.corsOrigins = stringList{"https://*", "http://*"}
+	fs.Var((*stringList)(&cfg.corsOrigins), "cors.allowed-origins", "comma separated origins browsers may call the API from")
+
+	fs.DurationVar(&cfg.accessTTL, "tokens.access-ttl", 15*time.Minute, "lifetime of an authentication token")
+	fs.DurationVar(&cfg.refreshTTL, "tokens.refresh-ttl", 30*24*time.Hour, "lifetime of a refresh token")
+	fs.DurationVar(&cfg.idleTimeout, "tokens.idle-timeout", 0, "if non-zero, also expire authentication tokens after this long unused; tokens.access-ttl stays the absolute limit")
+	fs.IntVar(&cfg.maxSessions, "tokens.max-sessions", 10, "most concurrent sessions per user")
+	fs.DurationVar(&cfg.resetTTL, "tokens.reset-ttl", time.Hour, "lifetime of a password reset link")
+	fs.StringVar(&cfg.signingKey, "tokens.signing-key", "", "key for signing links sent by email; required outside development, where an empty key means a random one")
+
+	fs.DurationVar(&cfg.http.readTimeout, "http.read-timeout", 15*time.Second, "longest time to read a request")
+	fs.DurationVar(&cfg.http.writeTimeout, "http.write-timeout", 30*time.Second, "longest time to write a response")
+	fs.DurationVar(&cfg.http.idleTimeout, "http.idle-timeout", time.Minute, "how long idle keep-alive connections are kept")
+	fs.DurationVar(&cfg.drainTimeout, "http.drain-timeout", 30*time.Second, "how long shutdown waits for in-flight requests")
+
+	fs.StringVar(&cfg.db.dsn, "dsn", "", "Postgres connection string")
+	fs.Var((*stringList)(&cfg.db.replicaDSNs), "db.replica-dsns", "comma separated connection strings of read replicas")
+	fs.DurationVar(&cfg.db.healthCheck, "db.health-check-interval", 5*time.Second, "how often read replicas are health checked")
+	fs.IntVar(&cfg.db.maxOpenConns, "db.max-open-conns", 5, "most open database connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db.max-idle-conns", 5, "most idle database connections")
+	fs.DurationVar(&cfg.db.connMaxLifetime, "db.conn-max-lifetime", 5*time.Minute, "longest time a database connection is reused")
+	fs.DurationVar(&cfg.db.connMaxIdleTime, "db.conn-max-idle-time", 0, "longest time an idle database connection is kept; 0 keeps it")
+	fs.DurationVar(&cfg.db.stmtTimeout, "db.statement-timeout", 0, "cancel statements that run longer than this; 0 disables")
+	fs.DurationVar(&cfg.db.connectTimeout, "db.connect-timeout", time.Minute, "how long to keep trying to reach the database on startup")
+	fs.DurationVar(&cfg.db.connectBackoff, "db.connect-backoff", 500*time.Millisecond, "wait after the first failed connection attempt; doubles after each")
+	fs.DurationVar(&cfg.db.maxBackoff, "db.connect-max-backoff", 10*time.Second, "longest wait between connection attempts")
+
+	fs.DurationVar(&cfg.login.window, "login.window", time.Hour, "how far back failed sign ins are counted")
+	fs.IntVar(&cfg.login.delayAfter, "login.delay-after", 3, "failed sign ins to one account before further attempts are delayed")
+	fs.IntVar(&cfg.login.ipDelayAfter, "login.ip-delay-after", 20, "failed sign ins from one address before further attempts are delayed")
+	fs.DurationVar(&cfg.login.baseDelay, "login.base-delay", time.Second, "first delay between sign in attempts; doubles with each further failure")
+	fs.DurationVar(&cfg.login.maxDelay, "login.max-delay", 5*time.Minute, "longest delay between sign in attempts")
+	fs.IntVar(&cfg.login.lockoutAfter, "login.lockout-after", 10, "failed sign ins that lock an account")
+	fs.DurationVar(&cfg.login.lockoutDuration, "login.lockout-duration", 15*time.Minute, "how long an account stays locked after its last failed sign in")
+
+	fs.IntVar(&cfg.password.minLength, "password.min-length", 8, "fewest characters a password may have")
+	fs.IntVar(&cfg.password.maxLength, "password.max-length", 72, "most bytes a password may have; at most 72")
+	fs.BoolVar(&cfg.password.rejectCommon, "password.reject-common", true, "refuse passwords on the built-in list of common passwords")
+
+	fs.BoolVar(&cfg.registration.enabled, "registration.enabled", true, "let anyone create an account with POST /users/register")
+
+	fs.BoolVar(&cfg.verification.required, "verification.required", false, "refuse to sign in users who have not verified their email address")
+	fs.DurationVar(&cfg.verification.ttl, "verification.ttl", 48*time.Hour, "lifetime of an email verification link")
+	fs.DurationVar(&cfg.verification.resendInterval, "verification.resend-interval", 5*time.Minute, "shortest time between two verification emails to one user")
+
+	fs.StringVar(&cfg.coverDir, "covers.dir", "./uploads", "directory book covers are stored in")
+	fs.Int64Var(&cfg.maxCoverSize, "covers.max-size", 5<<20, "largest cover image accepted, in bytes")
+
+	fs.BoolVar(&cfg.searchFuzzy, "search.fuzzy", false, "fall back to trigram search when nothing matches; requires pg_trgm")
+
+	fs.StringVar(&cfg.mail.host, "mail.host", "localhost", "SMTP server host")
+	fs.IntVar(&cfg.mail.port, "mail.port", 1025, "SMTP server port")
+	fs.StringVar(&cfg.mail.username, "mail.username", "", "SMTP user name")
+	fs.StringVar(&cfg.mail.password, "mail.password", "", "SMTP password")
+	fs.StringVar(&cfg.mail.sender, "mail.sender", "Library <no-reply@example.com>", "From address of outgoing mail")
+	fs.IntVar(&cfg.mail.workers, "mail.workers", 2, "how many messages are delivered at once")
+	fs.IntVar(&cfg.mail.attempts, "mail.max-attempts", 5, "how many times delivering a message is tried before giving up")
+
+	return fs
+}
+
+// envName returns the environment variable that sets a flag: its name in upper case, with
+// dots and hyphens replaced by underscores, so that tokens.access-ttl is TOKENS_ACCESS_TTL.
+func envName(flagName string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flagName))
+}
+
+// loadConfig builds the configuration from, in increasing order of precedence: the
+// defaults, the configuration file named by -config or CONFIG, the environment, and the
+// command line flags. The result is validated before it is returned.
+//
+// Parameters:
+//   - args: The command line arguments, without the program name.
+//   - lookupEnv: Looks up an environment variable, as os.LookupEnv does.
+//
+// Returns:
+//   - The configuration.
+//   - Whether -print-config was given.
+//   - An error if any source could not be read, or the configuration is invalid.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (config, bool, error) {
+	var cfg config
+	fs := cfg.flagSet()
+
+	// parse once to learn which configuration file to read, then again after the file
+	// and the environment have been applied, so that flags take precedence over both
+	err := fs.Parse(args)
+	if err != nil {
+		return cfg, false, err
+	}
+
+	path := fs.Lookup("config").Value.String()
+	if env, ok := lookupEnv("CONFIG"); ok && path == "" {
+		path = env
+	}
+
+	if path != "" {
+		settings, err := readConfigFile(path)
+		if err != nil {
+			return cfg, false, err
+		}
+
+		for _, key := range sortedKeys(settings) {
+			f := fs.Lookup(key)
+			if f == nil || key == "config" || key == "print-config" {
+				return cfg, false, fmt.Errorf("%s: unknown setting %q", path, key)
+			}
+
+			if err := f.Value.Set(settings[key]); err != nil {
+				return cfg, false, fmt.Errorf("%s: invalid value for %s: %w", path, key, err)
+			}
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		if value, ok := lookupEnv(envName(f.Name)); ok {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("invalid value for %s: %w", envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return cfg, false, err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return cfg, false, err
+	}
+
+	printConfig := fs.Lookup("print-config").Value.String() == "true"
+
+	return cfg, printConfig, cfg.validate()
+}
+
+// validate checks that every setting has a usable value, and returns an error listing
+// each one that does not.
+func (cfg *config) validate() error {
+	var errs []error
+
+	check := func(ok bool, setting, message string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s %s", setting, message))
+		}
+	}
+
+	check(permittedValue(cfg.env, environments...), "env", "must be one of "+strings.Join(environments, ", "))
+	check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	check(permittedValue(cfg.logLevel, logLevels...), "log-level", "must be one of "+strings.Join(logLevels, ", "))
+	check(len(cfg.corsOrigins) > 0, "cors.allowed-origins", "must list at least one origin")
+	check(absoluteURL(cfg.frontendURL), "frontend-url", "must be an absolute http or https URL")
+	check(absoluteURL(cfg.publicURL), "public-url", "must be an absolute http or https URL")
+
+	check(cfg.accessTTL > 0, "tokens.access-ttl", "must be positive")
+	check(cfg.refreshTTL > cfg.accessTTL, "tokens.refresh-ttl", "must be longer than tokens.access-ttl")
+	check(cfg.idleTimeout >= 0, "tokens.idle-timeout", "must not be negative")
+	check(cfg.maxSessions > 0, "tokens.max-sessions", "must be at least 1")
+	check(cfg.resetTTL > 0, "tokens.reset-ttl", "must be positive")
+	// a random key would break every emailed link on restart, and between instances
+	check(cfg.signingKey != "" || cfg.env == "development", "tokens.signing-key", "must be set outside development")
+	check(cfg.signingKey == "" || len(cfg.signingKey) >= 32, "tokens.signing-key", "must be at least 32 characters long")
+
+	check(cfg.http.readTimeout > 0, "http.read-timeout", "must be positive")
+	check(cfg.http.writeTimeout > 0, "http.write-timeout", "must be positive")
+	check(cfg.http.idleTimeout > 0, "http.idle-timeout", "must be positive")
+	check(cfg.drainTimeout > 0, "http.drain-timeout", "must be positive")
+
+	check(cfg.db.dsn != "", "dsn", "must be provided")
+	check(cfg.db.healthCheck > 0, "db.health-check-interval", "must be positive")
+	check(cfg.db.maxOpenConns > 0, "db.max-open-conns", "must be at least 1")
+	check(cfg.db.maxIdleConns >= 0 && cfg.db.maxIdleConns <= cfg.db.maxOpenConns,
+		"db.max-idle-conns", "must be between 0 and db.max-open-conns")
+	check(cfg.db.connMaxLifetime >= 0, "db.conn-max-lifetime", "must not be negative")
+	check(cfg.db.connMaxIdleTime >= 0, "db.conn-max-idle-time", "must not be negative")
+	check(cfg.db.stmtTimeout >= 0, "db.statement-timeout", "must not be negative")
+	check(cfg.db.connectTimeout >= 0, "db.connect-timeout", "must not be negative")
+	check(cfg.db.connectBackoff > 0, "db.connect-backoff", "must be positive")
+	check(cfg.db.maxBackoff >= cfg.db.connectBackoff, "db.connect-max-backoff", "must be at least db.connect-backoff")
+
+	check(cfg.login.window > 0 && cfg.login.window >= cfg.login.lockoutDuration,
+		"login.window", "must be positive and at least login.lockout-duration")
+	check(cfg.login.delayAfter >= 0, "login.delay-after", "must not be negative")
+	check(cfg.login.ipDelayAfter >= 0, "login.ip-delay-after", "must not be negative")
+	check(cfg.login.baseDelay > 0, "login.base-delay", "must be positive")
+	check(cfg.login.maxDelay >= cfg.login.baseDelay, "login.max-delay", "must be at least login.base-delay")
+	check(cfg.login.lockoutAfter > 0, "login.lockout-after", "must be at least 1")
+	check(cfg.login.lockoutDuration > 0, "login.lockout-duration", "must be positive")
+
+	check(cfg.password.minLength > 0, "password.min-length", "must be at least 1")
+	check(cfg.password.maxLength >= cfg.password.minLength && cfg.password.maxLength <= 72,
+		"password.max-length", "must be between password.min-length and 72")
+
+	check(cfg.verification.ttl > 0, "verification.ttl", "must be positive")
+	check(cfg.verification.resendInterval >= 0, "verification.resend-interval", "must not be negative")
+
+	check(cfg.coverDir != "", "covers.dir", "must be provided")
+	check(cfg.maxCoverSize > 0, "covers.max-size", "must be positive")
+
+	check(cfg.mail.host != "", "mail.host", "must be provided")
+	check(cfg.mail.port > 0 && cfg.mail.port <= 65535, "mail.port", "must be between 1 and 65535")
+	check(cfg.mail.sender != "", "mail.sender", "must be provided")
+	check(cfg.mail.workers > 0, "mail.workers", "must be at least 1")
+	check(cfg.mail.attempts > 0, "mail.max-attempts", "must be at least 1")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// absoluteURL reports whether s is an absolute http or https URL.
+func absoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// print writes the configuration to w in TOML, for a person to read. Secrets are
+// redacted, so the output is not the configuration itself and cannot be loaded as a
+// configuration file; a header says so.
+func (cfg *config) print(w io.Writer) error {
+	// the flags point into c, and flagSet stores the defaults there; overwriting c
+	// afterwards makes the flags report this configuration's values instead
+	var c config
+	fs := c.flagSet()
+	c = *cfg
+
+	sections := make(map[string][]string)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		section, key, found := strings.Cut(f.Name, ".")
+		if !found {
+			section, key = "", f.Name
+		}
+
+		value := f.Value.(flag.Getter).Get()
+		if secretSettings[f.Name] {
+			switch v := value.(type) {
+			case string:
+				value = redact(f.Name, v)
+			case []string:
+				redacted := make([]string, len(v))
+				for i, s := range v {
+					redacted[i] = redact(f.Name, s)
+				}
+				value = redacted
+			}
+		}
+
+		sections[section] = append(sections[section], fmt.Sprintf("%s = %s", key, tomlValue(value)))
+	})
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprint(bw, printHeader)
+	fmt.Fprintln(bw)
+
+	for _, section := range sortedKeys(sections) {
+		if section != "" {
+			fmt.Fprintf(bw, "\n[%s]\n", section)
+		}
+
+		for _, line := range sections[section] {
+			fmt.Fprintln(bw, line)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// printHeader starts the output of print.
+const printHeader = `# Effective configuration, for reading only. Secrets are redacted, so this is not
+# a usable configuration file: values shown as REDACTED are not the real ones.
+`
+
+// keywordPassword matches the password in a keyword/value connection string.
+var keywordPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redact hides a secret setting. Only the password is removed from a connection string,
+// since the rest of it is useful when checking which database is being used.
+func redact(name, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	if name != "dsn" && name != "db.replica-dsns" {
+		return "REDACTED"
+	}
+
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", "REDACTED")
+			u.RawQuery = q.Encode()
+		}
+
+		return u.String()
+	}
+
+	return keywordPassword.ReplaceAllString(value, "${1}REDACTED")
+}
+
+// tomlValue formats a setting's value as a TOML value.
+func tomlValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case time.Duration:
+		return strconv.Quote(v.String())
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConfigPrecedence checks that flags override the environment, which overrides the
+// configuration file, which overrides the defaults.
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.yaml")
+	err := os.WriteFile(path, []byte(`
+port: 9000
+dsn: "host=db password=secret"
+tokens:
+  access-ttl: 5m
+  max-sessions: 3
+cors:
+  allowed-origins:
+    - https://a.example
+    - https://b.example
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"CONFIG": path, "PORT": "9001", "TOKENS_MAX_SESSIONS": "4"}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	cfg, _, err := loadConfig([]string{"-tokens.max-sessions", "5"}, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.port != 9001 {
+		t.Errorf("port = %d; want 9001 from the environment", cfg.port)
+	}
+	if cfg.accessTTL != 5*time.Minute {
+		t.Errorf("access TTL = %s; want 5m from the file", cfg.accessTTL)
+	}
+	if cfg.maxSessions != 5 {
+		t.Errorf("max sessions = %d; want 5 from the flag", cfg.maxSessions)
+	}
+	if cfg.refreshTTL != 30*24*time.Hour {
+		t.Errorf("refresh TTL = %s; want the default", cfg.refreshTTL)
+	}
+	if len(cfg.corsOrigins) != 2 || cfg.corsOrigins[1] != "https://b.example" {
+		t.Errorf("CORS origins = %q; want the two from the file", cfg.corsOrigins)
+	}
+}
+
+// TestReadConfigFile checks that both file formats are read as their specifications say,
+// including the parts that are easy to get wrong.
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name: "multi-line TOML array",
+			file: "api.toml",
+			contents: `[cors]
+allowed-origins = [
+    "https://a.example",  # the shop
+    "https://b.example",
+]
+`,
+			want: map[string]string{"cors.allowed-origins": "https://a.example,https://b.example"},
+		},
+		{
+			name: "TOML literal string has no escapes",
+			file: "api.toml",
+			contents: `[covers]
+dir = 'C:\covers\new'
+`,
+			want: map[string]string{"covers.dir": `C:\covers\new`},
+		},
+		{
+			name:     "TOML literal string cannot hold a quote",
+			file:     "api.toml",
+			contents: "dsn = 'host=db password=it''s'\n",
+			wantErr:  true,
+		},
+		{
+			name: "YAML sections and block lists",
+			file: "api.yaml",
+			contents: `port: 9000
+dsn: 'host=db password=it''s'
+tokens:
+  access-ttl: 5m
+cors:
+  allowed-origins:
+    - https://a.example
+    - https://b.example
+`,
+			want: map[string]string{
+				"port":                 "9000",
+				"dsn":                  "host=db password=it's",
+				"tokens.access-ttl":    "5m",
+				"cors.allowed-origins": "https://a.example,https://b.example",
+			},
+		},
+		{
+			name: "nested sections",
+			file: "api.yaml",
+			contents: `db:
+  pool:
+    max-open-conns: 5
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := os.WriteFile(path, []byte(tt.contents), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			settings, err := readConfigFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("read %v; want an error", settings)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(settings, tt.want) {
+				t.Errorf("settings = %q; want %q", settings, tt.want)
+			}
+		})
+	}
+}
+
+// TestPrintConfigRedactsSecrets checks that printed configuration hides secrets, keeps
+// the rest of a connection string, and says that it is not a usable configuration file.
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+
+	args := []string{
+		"-dsn", "postgres://app:secret@db/goapi",
+		"-mail.password", "hunter2",
+		"-tokens.signing-key", strings.Repeat("k", 32),
+	}
+
+	cfg, _, err := loadConfig(args, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = cfg.print(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	for _, secret := range []string{"secret", "hunter2", strings.Repeat("k", 32)} {
+		if strings.Contains(out, secret) {
+			t.Errorf("printed configuration contains %q:\n%s", secret, out)
+		}
+	}
+
+	if !strings.HasPrefix(out, printHeader) {
+		t.Errorf("printed configuration does not start with the header:\n%s", out)
+	}
+	if !strings.Contains(out, `dsn = "postgres://app:REDACTED@db/goapi"`) {
+		t.Errorf("printed configuration lost the rest of the DSN:\n%s", out)
+	}
+}
+
+// TestSigningKeyRequiredOutsideDevelopment checks that only development may run without a
+// signing key.
+func TestSigningKeyRequiredOutsideDevelopment(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+
+	_, _, err := loadConfig([]string{"-dsn", "host=db", "-env", "development"}, noEnv)
+	if err != nil {
+		t.Errorf("development without a signing key: %v", err)
+	}
+
+	_, _, err = loadConfig([]string{"-dsn", "host=db", "-env", "production"}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "tokens.signing-key") {
+		t.Errorf("production without a signing key: error = %v; want one about tokens.signing-key", err)
+	}
+
+	key := strings.Repeat("k", 32)
+	_, _, err = loadConfig([]string{"-dsn", "host=db", "-env", "production", "-tokens.signing-key", key}, noEnv)
+	if err != nil {
+		t.Errorf("production with a signing key: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// readConfigFile reads the settings in a configuration file, keyed by flag name. The
+// format is chosen by the extension: .toml for TOML, and .yaml or .yml for YAML. A file
+// holds settings outside any section, and sections, one level deep, of settings; the part
+// of a flag name before the dot names its section. Values are turned back into the text
+// a flag would be given, with lists joined by commas, as a stringList expects.
+//
+// Parameters:
+//   - path: The path of the file.
+//
+// Returns:
+//   - The settings, keyed by "section.key", or just "key" outside any section.
+//   - An error if the file could not be read or parsed.
+func readConfigFile(path string) (map[string]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]any
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(contents, &document)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &document)
+	default:
+		return nil, fmt.Errorf("%s: unsupported configuration file type; use .toml, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+
+	for key, value := range document {
+		section, ok := value.(map[string]any)
+		if !ok {
+			settings[key], err = settingValue(key, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			continue
+		}
+
+		for sectionKey, value := range section {
+			name := key + "." + sectionKey
+			settings[name], err = settingValue(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+
+	return settings, nil
+}
+
+// settingValue turns a value decoded from a configuration file into the text a flag would
+// be given: a string, number or boolean as written, and a list of them joined by commas.
+func settingValue(name string, value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			switch item.(type) {
+			case string, bool, int, int64, uint64, float64:
+				items[i] = fmt.Sprint(item)
+			default:
+				return "", fmt.Errorf("%s: lists may only hold strings, numbers and booleans", name)
+			}
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("%s: sections cannot be nested", name)
+	default:
+		return "", fmt.Errorf("%s: unsupported value %v", name, v)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+	"github.com/polyglotdev/vue-api/internal/imaging"
+	"github.com/polyglotdev/vue-api/internal/storage"
+)
+
+const (
+	// thumbnailWidth and thumbnailHeight bound the size of generated cover thumbnails.
+	thumbnailWidth  = 200
+	thumbnailHeight = 300
+	// maxCoverPixels guards against decompression bombs: tiny files that decode into
+	// enormous images.
+	maxCoverPixels = 40_000_000
+)
+
+// coverExtensions maps each content type accepted for a cover image to the file extension
+// it is stored with.
+var coverExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// UploadCover is the handler used by administrators to upload a cover image for the book
+// named by the "id" URL parameter. It expects a multipart form with the image in a field
+// called "cover". JPEG, PNG and GIF images are accepted, up to the configured size limit.
+// A JPEG thumbnail is generated alongside the original, and any previous cover is removed.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) UploadCover(w http.ResponseWriter, r *http.Request) {
+	book := app.fetchBookByID(w, r)
+	if book == nil {
+		return
+	}
+
+	// allow some room for the multipart headers on top of the image itself
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.maxCoverSize+1<<20)
+
+	file, _, err := r.FormFile("cover")
+	if err != nil {
+		app.errorJson(w, errors.New("a cover image must be uploaded in the cover field"))
+		return
+	}
+	defer file.Close()
+
+	upload, err := io.ReadAll(io.LimitReader(file, app.config.maxCoverSize+1))
+	if err != nil {
+		app.errorJson(w, errors.New("the cover image could not be read"))
+		return
+	}
+
+	if int64(len(upload)) > app.config.maxCoverSize {
+		app.errorJson(w, fmt.Errorf("the cover image must not be larger than %d bytes", app.config.maxCoverSize),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	contentType := http.DetectContentType(upload)
+	ext, ok := coverExtensions[contentType]
+	if !ok {
+		app.errorJson(w, errors.New("the cover image must be a JPEG, PNG or GIF"), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil || cfg.Width*cfg.Height > maxCoverPixels {
+		app.errorJson(w, errors.New("the cover image is invalid or too large to process"))
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		app.errorJson(w, errors.New("the cover image is invalid"))
+		return
+	}
+
+	var thumbnail bytes.Buffer
+	err = jpeg.Encode(&thumbnail, imaging.Thumbnail(img, thumbnailWidth, thumbnailHeight), &jpeg.Options{Quality: 85})
+	if err != nil {
+		app.errorLog.Println("error encoding thumbnail:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// every upload gets a fresh key, so covers can be cached forever
+	name := make([]byte, 8)
+	_, err = rand.Read(name)
+	if err != nil {
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	key := fmt.Sprintf("covers/%d/%s%s", book.ID, hex.EncodeToString(name), ext)
+
+	err = app.storage.Put(r.Context(), key, bytes.NewReader(upload), contentType)
+	if err != nil {
+		app.errorLog.Println("error storing cover:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.storage.Put(r.Context(), data.ThumbnailPath(key), &thumbnail, "image/jpeg")
+	if err != nil {
+		app.errorLog.Println("error storing thumbnail:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = book.SetCover(key)
+	if err != nil {
+		app.errorLog.Println("error saving cover path:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if book.CoverPath != "" {
+		app.deleteCover(r, book.CoverPath)
+	}
+	book.CoverPath = key
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Cover uploaded",
+		Data: envelope{
+			"book":          book,
+			"cover_url":     "/" + key,
+			"thumbnail_url": "/" + data.ThumbnailPath(key),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// deleteCover removes a cover image and its thumbnail from storage, logging any failure.
+// A leftover file is harmless, so failures are not reported to the client.
+func (app *application) deleteCover(r *http.Request, coverPath string) {
+	for _, key := range []string{coverPath, data.ThumbnailPath(coverPath)} {
+		err := app.storage.Delete(r.Context(), key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			app.errorLog.Println("error deleting cover:", err)
+		}
+	}
+}
+
+// ServeCover is the handler used to serve cover images and thumbnails from storage. Each
+// upload is stored under a new key, so responses may be cached indefinitely.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) ServeCover(w http.ResponseWriter, r *http.Request) {
+	key := "covers/" + chi.URLParam(r, "*")
+
+	obj, err := app.storage.Open(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+			app.errorJson(w, errors.New("cover not found"), http.StatusNotFound)
+			return
+		}
+
+		app.errorLog.Println("error opening cover:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, obj.ModTime.UnixNano(), obj.Size))
+	if obj.ContentType != "" {
+		w.Header().Set("Content-Type", obj.ContentType)
+	}
+
+	http.ServeContent(w, r, key, obj.ModTime, obj)
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"time"
+
+	"github.com/polyglotdev/vue-api/internal/data"
 )
 
 // jsonResponse is the type used for generic JSON responses
@@ -12,7 +16,7 @@ import (
 //   - message: A string containing a message describing the result of the request.
 type jsonResponse struct {
 	// Error is a boolean indicating whether an error occurred during the request.
-			Error bool `json:"error"`
+	Error bool `json:"error"`
 	// Message is a string containing a message describing the result of the request.
 	Message string `json:"message"`
 
@@ -26,10 +30,18 @@ type envelope map[string]interface{}
 // It expects a JSON object with the following fields:
 //   - email: The email address of the user to log in.
 //   - password: The password of the user to log in.
+//   - device: An optional label for the device, shown in the user's list of sessions.
 //
 // It returns a JSON response with the following fields:
 //   - error: A boolean indicating whether an error occurred during the login process.
 //   - message: A string containing a message describing the result of the login process.
+//   - data: The tokens, and whether the user's email address has been verified. If
+//     verification.required is set, unverified users are refused with 403 instead.
+//
+// Every failure is recorded. After login.delay-after failures for an account, or
+// login.ip-delay-after from one address, each further attempt must wait a delay that
+// doubles with each failure, and after login.lockout-after failures the account is locked
+// for login.lockout-duration; attempts made too soon get 429 with a Retry-After header.
 //
 // Parameters:
 //   - w: The HTTP response writer.
@@ -41,6 +53,7 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	type credentials struct {
 		Username string `json:"email"`
 		Password string `json:"password"`
+		Device   string `json:"device"`
 	}
 
 	var creds credentials
@@ -55,54 +68,381 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO authenticate
-	app.infoLog.Println(creds.Username, creds.Password)
+	creds.Username = normalizeEmail(creds.Username)
 
-	// lookup user by email
+	// lookup user by email; an unknown address gets the same responses, after the same
+	// time spent checking a password, as a registered one with a wrong password
 	user, err := app.models.User.GetByEmail(creds.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.errorLog.Println("error fetching user:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// record the attempt as a failure before checking anything, counting the failures
+	// before it in the same step, so that attempts made at once cannot all get through
+	// before any of them is counted; failures are counted by email address, so unknown
+	// addresses are treated just like registered ones
+	attempt := newLoginAttempt(r, creds.Username, user)
+	failures, err := app.models.LoginAttempt.Record(&attempt, time.Now().Add(-app.config.login.window))
 	if err != nil {
-		app.errorLog.Println("invalid username/password:", err)
+		app.errorLog.Println("error recording sign in attempt:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if wait, locked := app.loginWait(failures, time.Now()); wait > 0 {
+		reason := data.LoginFailedThrottled
+		if locked {
+			reason = data.LoginFailedLocked
+		}
+
+		app.recordFailedLogin(&attempt, reason)
+		app.tooManyLogins(w, wait)
+		return
+	}
+
+	validPassword := false
+	if user != nil {
+		validPassword, err = user.PasswordMatches(creds.Password)
+		if err != nil {
+			app.errorLog.Println("error checking password:", err)
+		}
+	} else {
+		data.DummyPasswordCheck(creds.Password)
+	}
+
+	if !validPassword {
+		app.recordFailedLogin(&attempt, data.LoginFailedCredentials)
+		if failures.Account+1 == app.config.login.lockoutAfter {
+			app.infoLog.Printf("sign in to %q locked for %s after %d failures", creds.Username,
+				app.config.login.lockoutDuration, app.config.login.lockoutAfter)
+		}
+
 		payload.Error = true
-		payload.Message = "user not found"
+		payload.Message = "invalid email or password"
 		_ = app.writeJSON(w, http.StatusBadRequest, payload)
 		return
 	}
 
-	// validate user's password
-	validPassword, err := user.PasswordMatches(creds.Password)
-	if err != nil || !validPassword {
-		app.errorLog.Println("invalid username/password:", err)
+	// users who have not verified their email address may be refused, depending on
+	// configuration; otherwise the response tells the client so that it can remind them
+	if user.VerifiedAt == nil && app.config.verification.required {
+		app.recordFailedLogin(&attempt, data.LoginFailedUnverified)
 		payload.Error = true
-		payload.Message = "invalid username/password"
-		_ = app.writeJSON(w, http.StatusBadRequest, payload)
+		payload.Message = "email address not verified"
+		_ = app.writeJSON(w, http.StatusForbidden, payload)
 		return
 	}
 
-	// generate a token
-	token, err := app.models.User.Token.GenerateToken(user.ID, 24*time.Hour)
+	// a successful sign in is not a failure after all, and wipes the slate clean for the
+	// account, though not for the address it came from
+	err = app.models.LoginAttempt.Delete(attempt.ID)
 	if err != nil {
-		app.errorLog.Println("error generating token:", err)
+		app.errorLog.Println("error removing sign in attempt:", err)
+	}
+
+	_, err = app.models.LoginAttempt.Clear(creds.Username)
+	if err != nil {
+		app.errorLog.Println("error clearing failed sign ins:", err)
+	}
+
+	// generate and save a new pair of tokens
+	token, refreshToken, err := app.issueTokens(r, user, "", creds.Device)
+	if err != nil {
+		app.errorLog.Println("error issuing tokens:", err)
 		payload.Error = true
 		payload.Message = "error generating token"
 		_ = app.writeJSON(w, http.StatusBadRequest, payload)
 		return
 	}
 
-	// save to database
-	err = app.models.User.Token.Insert(*token, *user)
+	// send back a response
+	payload = jsonResponse{
+		Error:   false,
+		Message: "Signed in",
+		Data:    envelope{"token": token, "refresh_token": refreshToken, "email_verified": user.VerifiedAt != nil},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// issueTokens generates a short-lived authentication token and a long-lived refresh token
+// for a user, saves both, and evicts the user's oldest sessions if they now hold too many.
+//
+// Parameters:
+//   - r: The HTTP request, used to record which device the session belongs to.
+//   - user: The user to issue the tokens to.
+//   - familyID: The token family to place the tokens in, or "" to start a new session.
+//   - device: A label for the device the session belongs to.
+//
+// Returns:
+//   - The authentication token.
+//   - The refresh token.
+//   - An error if the tokens could not be generated or saved.
+func (app *application) issueTokens(r *http.Request, user *data.User, familyID, device string) (*data.Token, *data.Token, error) {
+	// with an idle timeout, a token starts out expiring once it has gone unused that long,
+	// and each request slides the expiry on, up to tokens.access-ttl from its creation
+	ttl := app.config.accessTTL
+	if app.config.idleTimeout > 0 {
+		ttl = min(ttl, app.config.idleTimeout)
+	}
+
+	token, err := app.models.Token.GenerateToken(user.ID, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := app.models.Token.GenerateToken(user.ID, app.config.refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if familyID == "" {
+		familyID = token.FamilyID
+	}
+
+	for _, t := range []*data.Token{token, refreshToken} {
+		t.FamilyID = familyID
+		t.UserAgent = r.UserAgent()
+		t.IPAddress = clientIP(r)
+		t.DeviceLabel = device
+	}
+	refreshToken.Scope = data.ScopeRefresh
+
+	err = app.models.Token.Insert(*token, *user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = app.models.Token.Insert(*refreshToken, *user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// evict the oldest sessions if the user now holds too many
+	err = app.models.Token.PruneForUser(user.ID, app.config.maxSessions)
+	if err != nil {
+		app.errorLog.Println("error pruning sessions:", err)
+	}
+
+	return token, refreshToken, nil
+}
+
+// Refresh is the handler used to exchange a refresh token for a new authentication token
+// and a new refresh token. Refresh tokens rotate: each one may only be used once, and
+// presenting one a second time revokes the whole session.
+//
+// It expects a JSON object with the following fields:
+//   - refresh_token: The refresh token returned by Login or by a previous call to Refresh.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	var payload jsonResponse
+
+	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.errorLog.Println("error saving token:", err)
+		app.errorLog.Println("Error while reading JSON:", err)
 		payload.Error = true
-		payload.Message = "error saving token"
+		payload.Message = "invalid json supplied, or json missing entirely"
 		_ = app.writeJSON(w, http.StatusBadRequest, payload)
 		return
 	}
 
-	// send back a response
+	used, err := app.models.Token.UseRefreshToken(input.RefreshToken)
+	if err != nil {
+		app.errorLog.Println("error using refresh token:", err)
+		payload.Error = true
+		payload.Message = "invalid or expired refresh token"
+		if errors.Is(err, data.ErrTokenReused) {
+			payload.Message = "refresh token reused; session revoked"
+		}
+		_ = app.writeJSON(w, http.StatusUnauthorized, payload)
+		return
+	}
+
+	user, err := app.models.Token.GetUserForToken(*used)
+	if err != nil {
+		app.errorLog.Println("error fetching user for token:", err)
+		payload.Error = true
+		payload.Message = "invalid or expired refresh token"
+		_ = app.writeJSON(w, http.StatusUnauthorized, payload)
+		return
+	}
+
+	token, refreshToken, err := app.issueTokens(r, user, used.FamilyID, used.DeviceLabel)
+	if err != nil {
+		app.errorLog.Println("error issuing tokens:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	payload = jsonResponse{
 		Error:   false,
-		Message: "Signed in",
-		Data:    envelope{"token": token},
+		Message: "Token refreshed",
+		Data:    envelope{"token": token, "refresh_token": refreshToken},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// Logout is the handler used to revoke the bearer token sent with the request, along
+// with the refresh token issued alongside it. It is idempotent: revoking a token that has
+// already been revoked, or that has expired, still reports success.
+//
+// It returns a JSON response with the following fields:
+//   - error: A boolean indicating whether an error occurred during the logout process.
+//   - message: A string containing a message describing the result of the logout process.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	var payload jsonResponse
+
+	token, err := data.BearerToken(r)
+	if err != nil {
+		app.errorLog.Println("error reading bearer token:", err)
+		payload.Error = true
+		payload.Message = "no valid token supplied"
+		_ = app.writeJSON(w, http.StatusBadRequest, payload)
+		return
+	}
+
+	err = app.models.Token.DeleteByToken(token)
+	if err != nil {
+		app.errorLog.Println("error deleting token:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload = jsonResponse{
+		Error:   false,
+		Message: "Signed out",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// LogoutAll is the handler used to revoke every token belonging to the authenticated
+// user, signing them out on every device. It must be mounted behind AuthTokenMiddleware.
+//
+// It returns a JSON response with the following fields:
+//   - error: A boolean indicating whether an error occurred during the logout process.
+//   - message: A string containing a message describing the result of the logout process.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Token.DeleteByUserID(user.ID)
+	if err != nil {
+		app.errorLog.Println("error deleting tokens:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Signed out of all devices",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// session is the representation of a token returned by the Sessions handler. Current
+// is true for the session that made the request.
+type session struct {
+	*data.Token
+	Current bool `json:"current"`
+}
+
+// Sessions is the handler used to list the authenticated user's active sessions,
+// newest first. It must be mounted behind AuthTokenMiddleware.
+//
+// It returns a JSON response with the following fields:
+//   - error: A boolean indicating whether an error occurred.
+//   - message: A string containing a message describing the result.
+//   - data: An object whose sessions field holds the list of sessions.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) Sessions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	tokens, err := app.models.Token.GetAllForUser(user.ID)
+	if err != nil {
+		app.errorLog.Println("error fetching sessions:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the middleware has already validated the header, so the error can be ignored
+	plainText, _ := data.BearerToken(r)
+
+	sessions := make([]session, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, session{Token: t, Current: t.MatchesPlainText(plainText)})
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Sessions retrieved",
+		Data:    envelope{"sessions": sessions},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// RevokeSession is the handler used to revoke a single session belonging to the
+// authenticated user, by id. Revoking a session that no longer exists still reports
+// success. It must be mounted behind AuthTokenMiddleware.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	err = app.models.Token.DeleteForUser(id, user.ID)
+	if err != nil {
+		app.errorLog.Println("error deleting session:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Session revoked",
 	}
 
 	err = app.writeJSON(w, http.StatusOK, payload)
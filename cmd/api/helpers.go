@@ -4,8 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
 // readJSON reads and decodes JSON from an HTTP request body into the provided data structure.
@@ -100,3 +105,85 @@ func (app *application) errorJson(w http.ResponseWriter, err error, status ...in
 
 	_ = app.writeJSON(w, statusCode, payload)
 }
+
+// clientIP returns the IP address of the client that made the request, without the port.
+//
+// Parameters:
+//   - r: The HTTP request.
+//
+// Returns:
+//   - The client's IP address, or the raw remote address if it cannot be split.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// readIDParam reads the "id" URL parameter from the request and converts it to a positive int.
+//
+// Parameters:
+//   - r: The HTTP request.
+//
+// Returns:
+//   - The id, or an error if it is missing or not a positive integer.
+func (app *application) readIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
+
+// readString returns the value of key in the query string, or defaultValue if it is empty.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// readInt returns the value of key in the query string as an int, or defaultValue if it is
+// empty. If the value is not an integer, an error is recorded in v and defaultValue is returned.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.Check(false, key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// readCSVInts returns the value of key in the query string as a list of ints, split on
+// commas. It returns nil if the value is empty. If any element is not an integer, an
+// error is recorded in v and nil is returned.
+func (app *application) readCSVInts(qs url.Values, key string, v *validator) []int {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	var ints []int
+	for _, part := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			v.Check(false, key, "must be a comma separated list of integers")
+			return nil
+		}
+
+		ints = append(ints, i)
+	}
+
+	return ints
+}
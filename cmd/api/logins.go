@@ -0,0 +1,197 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// loginAttemptsLimit is the most failed sign ins UserLoginAttempts returns.
+const loginAttemptsLimit = 100
+
+// tooManyLoginsMessage is the response to a sign in refused because of earlier failures.
+// It is the same whether the attempt was delayed or the account is locked, and whether or
+// not the account exists.
+const tooManyLoginsMessage = "too many failed sign in attempts; try again later"
+
+// loginDelay returns how long to wait after the latest of a number of failed sign ins
+// before allowing another attempt: nothing until there have been free failures, then
+// base, doubling with each further failure up to ceiling.
+//
+// Parameters:
+//   - failures: The number of recent failures.
+//   - free: The number of failures allowed without a delay.
+//   - base: The delay after the first failure that is not free.
+//   - ceiling: The longest delay.
+//
+// Returns:
+//   - The delay.
+func loginDelay(failures, free int, base, ceiling time.Duration) time.Duration {
+	if failures == 0 || failures < free {
+		return 0
+	}
+
+	delay := base
+	for i := free; i < failures && delay < ceiling; i++ {
+		delay *= 2
+	}
+
+	return min(delay, ceiling)
+}
+
+// loginWait works out, from the recent failed sign ins for an account and from a client
+// address, how long the client must wait before it may try to sign in again.
+//
+// Parameters:
+//   - f: The recent failures.
+//   - now: The current time.
+//
+// Returns:
+//   - How long to wait; zero or less if the attempt may go ahead.
+//   - Whether the account is locked, rather than the attempt merely delayed.
+func (app *application) loginWait(f *data.LoginFailures, now time.Time) (time.Duration, bool) {
+	cfg := app.config.login
+
+	if f.Account >= cfg.lockoutAfter {
+		if wait := f.AccountLast.Add(cfg.lockoutDuration).Sub(now); wait > 0 {
+			return wait, true
+		}
+	}
+
+	wait := f.AccountLast.Add(loginDelay(f.Account, cfg.delayAfter, cfg.baseDelay, cfg.maxDelay)).Sub(now)
+	ipWait := f.AddressLast.Add(loginDelay(f.Address, cfg.ipDelayAfter, cfg.baseDelay, cfg.maxDelay)).Sub(now)
+
+	return max(wait, ipWait), false
+}
+
+// newLoginAttempt describes a sign in attempt, ready to be recorded before its password
+// is checked.
+//
+// Parameters:
+//   - r: The HTTP request that is trying to sign in.
+//   - email: The email address it is trying to sign in with.
+//   - user: The user with that email address, or nil if there is none.
+//
+// Returns:
+//   - The attempt.
+func newLoginAttempt(r *http.Request, email string, user *data.User) data.LoginAttempt {
+	attempt := data.LoginAttempt{
+		Email:     email,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+
+	return attempt
+}
+
+// recordFailedLogin logs a failed sign in and, if it failed for some other reason than
+// the bad credentials it was recorded with, corrects the audit trail.
+//
+// Parameters:
+//   - attempt: The attempt, as recorded before its password was checked.
+//   - reason: Why it failed; one of the data.LoginFailed constants.
+func (app *application) recordFailedLogin(attempt *data.LoginAttempt, reason string) {
+	app.infoLog.Printf("failed sign in for %q from %s: %s", attempt.Email, attempt.IPAddress, reason)
+
+	if reason == attempt.Reason {
+		return
+	}
+
+	err := app.models.LoginAttempt.SetReason(attempt.ID, reason)
+	if err != nil {
+		app.errorLog.Println("error recording failed sign in:", err)
+	}
+}
+
+// tooManyLogins sends the response to a sign in refused because of earlier failures,
+// with a Retry-After header saying when to try again.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - wait: How long the client must wait.
+func (app *application) tooManyLogins(w http.ResponseWriter, wait time.Duration) {
+	headers := make(http.Header)
+	headers.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	payload := jsonResponse{
+		Error:   true,
+		Message: tooManyLoginsMessage,
+	}
+
+	_ = app.writeJSON(w, http.StatusTooManyRequests, payload, headers)
+}
+
+// UnlockUser is the handler used by administrators to unlock a user's account. Its
+// failed sign ins stop counting against it, which also lifts any delay on signing in
+// to it; they stay in the audit trail.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user := app.fetchUser(w, r)
+	if user == nil {
+		return
+	}
+
+	cleared, err := app.models.LoginAttempt.Clear(user.Email)
+	if err != nil {
+		app.errorLog.Println("error clearing failed sign ins:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.infoLog.Printf("user %d unlocked by user %d", user.ID, app.contextGetUser(r).ID)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Account unlocked",
+		Data:    envelope{"cleared_failures": cleared},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// UserLoginAttempts is the handler used by administrators to review the most recent
+// failed sign ins to a user's account, newest first.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) UserLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	user := app.fetchUser(w, r)
+	if user == nil {
+		return
+	}
+
+	attempts, err := app.models.LoginAttempt.GetAllForUser(user.ID, loginAttemptsLimit)
+	if err != nil {
+		app.errorLog.Println("error fetching failed sign ins:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if attempts == nil {
+		attempts = []*data.LoginAttempt{}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Failed sign ins retrieved",
+		Data:    envelope{"login_attempts": attempts},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
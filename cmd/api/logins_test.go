@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// TestLoginDelay checks that the delay starts after the free failures and doubles up to
+// the ceiling.
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{12, time.Minute},
+		{1000, time.Minute},
+	}
+
+	for _, tt := range tests {
+		got := loginDelay(tt.failures, 3, time.Second, time.Minute)
+		if got != tt.want {
+			t.Errorf("loginDelay(%d) = %s; want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// TestLoginWait checks that an account is locked after enough failures, and that
+// otherwise the longer of the account's and the address's delays applies.
+func TestLoginWait(t *testing.T) {
+	app := &application{}
+	app.config.login.delayAfter = 3
+	app.config.login.ipDelayAfter = 20
+	app.config.login.baseDelay = time.Second
+	app.config.login.maxDelay = 5 * time.Minute
+	app.config.login.lockoutAfter = 10
+	app.config.login.lockoutDuration = 15 * time.Minute
+
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		failures   data.LoginFailures
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{"no failures", data.LoginFailures{}, 0, false},
+		{"free failures", data.LoginFailures{Account: 2, AccountLast: now}, 0, false},
+		{"delayed", data.LoginFailures{Account: 4, AccountLast: now}, 2 * time.Second, false},
+		{"delay over", data.LoginFailures{Account: 4, AccountLast: now.Add(-time.Minute)}, 0, false},
+		{"address delayed", data.LoginFailures{Address: 21, AddressLast: now}, 2 * time.Second, false},
+		{"locked", data.LoginFailures{Account: 10, AccountLast: now}, 15 * time.Minute, true},
+		{"lock expired", data.LoginFailures{Account: 10, AccountLast: now.Add(-time.Hour)}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, locked := app.loginWait(&tt.failures, now)
+			if wait < 0 {
+				wait = 0
+			}
+
+			if wait != tt.wantWait || locked != tt.wantLocked {
+				t.Errorf("loginWait = %s, %v; want %s, %v", wait, locked, tt.wantWait, tt.wantLocked)
+			}
+		})
+	}
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
+	"sync"
 
 	"github.com/polyglotdev/vue-api/internal/data"
 	"github.com/polyglotdev/vue-api/internal/driver"
+	"github.com/polyglotdev/vue-api/internal/mailer"
+	"github.com/polyglotdev/vue-api/internal/migrations"
+	"github.com/polyglotdev/vue-api/internal/storage"
+	"github.com/sirupsen/logrus"
 )
 
-// config is the type for all application configuration
-type config struct {
-	port int // what port do we want the web server to listen on
-}
-
 // application is the type for all data we want to share with the
 // various parts of our application. We will share this information in most
 // cases by using this type as the receiver for functions
@@ -23,27 +28,104 @@ type application struct {
 	infoLog  *log.Logger
 	errorLog *log.Logger
 	models   data.Models
+	storage  storage.Storage
+	mailer   mailer.Mailer
+	db       *driver.Cluster
+	wg       sync.WaitGroup
 }
 
 func main() {
-	var cfg config
-	cfg.port = 8081
+	cfg, printConfig, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if printConfig {
+		if printErr := cfg.print(os.Stdout); printErr != nil {
+			log.Fatal(printErr)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		return
+	}
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	dsn := os.Getenv("DSN")
-	db, err := driver.ConnectPostgres(dsn)
+	if cfg.logLevel == "error" {
+		infoLog.SetOutput(io.Discard)
+		logrus.SetLevel(logrus.ErrorLevel)
+	}
+
+	db, err := driver.ConnectCluster(cfg.db.dsn, cfg.db.replicaDSNs,
+		driver.WithMaxOpenConns(cfg.db.maxOpenConns),
+		driver.WithMaxIdleConns(cfg.db.maxIdleConns),
+		driver.WithConnMaxLifetime(cfg.db.connMaxLifetime),
+		driver.WithConnMaxIdleTime(cfg.db.connMaxIdleTime),
+		driver.WithStatementTimeout(cfg.db.stmtTimeout),
+		driver.WithConnectRetry(cfg.db.connectTimeout, cfg.db.connectBackoff, cfg.db.maxBackoff),
+		driver.WithHealthCheckInterval(cfg.db.healthCheck),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.Publish("database")
+
+	if cfg.migrate {
+		err = runMigrations(db.Primary.SQL, infoLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	store, err := storage.NewLocal(cfg.coverDir)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
+
+	transport, err := mailer.NewSMTP(cfg.mail.host, cfg.mail.port, cfg.mail.username, cfg.mail.password, cfg.mail.sender)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailQueue := mailer.NewQueue(db.Primary.SQL, transport, cfg.mail.workers, cfg.mail.attempts)
+	mailQueue.Logf = errorLog.Printf
+	mailQueue.Start()
+
+	// validation only lets the key be left empty in development
+	if cfg.signingKey == "" {
+		key := make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.signingKey = string(key)
+		infoLog.Println("No signing key configured; using a random one, so links sent by email will stop working on restart")
+	}
 
 	app := &application{
 		config:   cfg,
 		infoLog:  infoLog,
 		errorLog: errorLog,
-		models:   data.New(db.SQL),
+		models:   data.NewWithReplicas(db.Primary.SQL, db.Reader),
+		storage:  store,
+		mailer:   mailQueue,
+		db:       db,
+	}
+
+	// fuzzy search needs pg_trgm, which the migrations only install where they may
+	if app.config.searchFuzzy {
+		available, err := app.models.Search.TrigramAvailable()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !available {
+			errorLog.Println("search.fuzzy is set, but the pg_trgm extension is not installed; fuzzy search is disabled")
+			app.config.searchFuzzy = false
+		}
 	}
 
 	err = app.serve()
@@ -52,14 +134,20 @@ func main() {
 	}
 }
 
-// serve starts the web server
-func (app *application) serve() error {
-	app.infoLog.Printf("API listening on port %d\n", app.config.port)
+// runMigrations applies any pending database migrations, logging each one.
+func runMigrations(db *sql.DB, infoLog *log.Logger) error {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	migrator.Logf = infoLog.Printf
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", app.config.port),
-		Handler: app.routes(),
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		return err
 	}
 
-	return srv.ListenAndServe()
+	infoLog.Printf("Applied %d database migration(s)\n", len(applied))
+
+	return nil
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// contextKey is the type used for keys stored in a request context. Using our own
+// unexported type means no other package can collide with the keys we set.
+type contextKey string
+
+// userContextKey is the key under which the authenticated user is stored.
+const userContextKey = contextKey("user")
+
+// contextSetUser returns a copy of the request with the given user stored in its context.
+//
+// Parameters:
+//   - r: The HTTP request.
+//   - user: The authenticated user to store.
+//
+// Returns:
+//   - A new request carrying the user in its context.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser returns the authenticated user stored in the request context by
+// AuthTokenMiddleware. It panics if called on a route that is not protected, since
+// that is always a programming error.
+//
+// Parameters:
+//   - r: The HTTP request.
+//
+// Returns:
+//   - The authenticated user.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// AuthTokenMiddleware authenticates the bearer token sent with the request. If the
+// token is valid, the associated user is placed in the request context and the next
+// handler is called; otherwise, a 401 response is sent back to the client.
+//
+// Parameters:
+//   - next: The handler to call once the request has been authenticated.
+//
+// Returns:
+//   - An http.Handler that enforces authentication.
+func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		// with an idle timeout configured, each request keeps the token alive a while longer
+		user, err := app.models.Token.AuthenticateToken(r, app.config.idleTimeout, app.config.accessTTL)
+		if err != nil {
+			app.errorLog.Println("authentication failed:", err)
+			payload := jsonResponse{
+				Error:   true,
+				Message: "invalid authentication credentials",
+			}
+
+			headers := make(http.Header)
+			headers.Set("WWW-Authenticate", "Bearer")
+			_ = app.writeJSON(w, http.StatusUnauthorized, payload, headers)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetUser(r, user))
+	})
+}
+
+// RequireAdmin rejects requests from users who are not administrators with a 403 response.
+// It must be used after AuthTokenMiddleware, which places the user in the request context.
+//
+// Parameters:
+//   - next: The handler to call if the user is an administrator.
+//
+// Returns:
+//   - An http.Handler that enforces administrator access.
+func (app *application) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if !user.IsAdmin {
+			payload := jsonResponse{
+				Error:   true,
+				Message: "you do not have permission to access this resource",
+			}
+			_ = app.writeJSON(w, http.StatusForbidden, payload)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// forgotPasswordMessage is the response to every valid forgotten password request,
+// whether or not the email address belongs to a user, so that the endpoint cannot be
+// used to find out which addresses are registered.
+const forgotPasswordMessage = "If that email address is registered, a link to reset the password has been sent to it"
+
+// ForgotPassword is the handler used to request a password reset link by email. The
+// link is signed, expires after the configured time, and stops working once it has been
+// used. The response is the same whether or not the address is registered, and the email
+// is sent in the background so that the response time does not tell either.
+//
+// It expects a JSON object with the following fields:
+//   - email: The email address of the account.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	input.Email = normalizeEmail(input.Email)
+
+	v := newValidator()
+	v.Check(validEmail(input.Email), "email", "must be a valid email address")
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	user, err := app.models.User.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		app.background(func() {
+			err := app.sendPasswordResetEmail(user)
+			if err != nil {
+				app.errorLog.Println("error sending password reset email:", err)
+			}
+		})
+	case !errors.Is(err, sql.ErrNoRows):
+		app.errorLog.Println("error fetching user:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: forgotPasswordMessage,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// sendPasswordResetEmail emails a user a link to the front end's password reset page,
+// carrying a newly signed reset token.
+func (app *application) sendPasswordResetEmail(user *data.User) error {
+	token := user.PasswordResetToken([]byte(app.config.signingKey), app.config.resetTTL)
+	link := strings.TrimRight(app.config.frontendURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+
+	return app.mailer.Send(user.Email, "password_reset.tmpl", map[string]any{
+		"FirstName": user.FirstName,
+		"Link":      link,
+		"TTL":       app.config.resetTTL,
+	})
+}
+
+// ResetPassword is the handler used to set a new password with a token from a password
+// reset email. Every session the user has is then signed out. An invalid token gets the
+// same response whether or not the user it names exists.
+//
+// It expects a JSON object with the following fields:
+//   - token: The token from the reset link.
+//   - password: The new password.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	v := newValidator()
+	v.Check(notBlank(input.Token), "token", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	user, err := app.models.User.GetForPasswordReset(input.Token, []byte(app.config.signingKey))
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidResetToken) {
+			v.Check(false, "token", "is invalid or has expired")
+			app.failedValidation(w, v)
+			return
+		}
+
+		app.errorLog.Println("error checking password reset token:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the password can only be checked once the token says whose it is
+	app.validatePassword(v, "password", input.Password, user.Email)
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	// the password is only replaced if it has not changed since the token was checked,
+	// so a second request with the same token fails here even if it got this far
+	err = user.ResetPasswordWithToken(input.Password)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidResetToken) {
+			v.Check(false, "token", "is invalid or has expired")
+			app.failedValidation(w, v)
+			return
+		}
+
+		app.errorLog.Println("error resetting password:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.models.Token.DeleteByUserID(user.ID)
+	if err != nil {
+		app.errorLog.Println("error deleting tokens:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password reset; please sign in with the new password",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
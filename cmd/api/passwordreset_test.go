@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+	"github.com/polyglotdev/vue-api/internal/mailer"
+)
+
+// TestPasswordResetEmail checks that the reset email goes to the user and links to the
+// front end's reset page with a token.
+func TestPasswordResetEmail(t *testing.T) {
+	fake := &mailer.Fake{}
+
+	app := &application{mailer: fake}
+	app.config.frontendURL = "http://localhost:8080/"
+	app.config.resetTTL = time.Hour
+	app.config.signingKey = strings.Repeat("k", 32)
+
+	user := &data.User{ID: 7, Email: "reader@example.com", FirstName: "Ann", Password: "hash"}
+
+	err := app.sendPasswordResetEmail(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := fake.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages; want 1", len(sent))
+	}
+
+	msg := sent[0]
+	if msg.To != user.Email {
+		t.Errorf("sent to %q; want %q", msg.To, user.Email)
+	}
+	for _, body := range []string{msg.PlainBody, msg.HTMLBody} {
+		if !strings.Contains(body, "http://localhost:8080/reset-password?token=") {
+			t.Errorf("body has no reset link:\n%s", body)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	_ "embed"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// commonPasswordList is the list of passwords refused for being too often used, one per
+// line. Blank lines and lines starting with # are ignored.
+//
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords holds the entries of commonPasswordList, in lower case.
+var commonPasswords = parsePasswordList(commonPasswordList)
+
+// parsePasswordList returns the set of passwords listed in list, in lower case.
+func parsePasswordList(list string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+
+	return passwords
+}
+
+// commonPassword reports whether password is on the list of common passwords, ignoring
+// case.
+func commonPassword(password string) bool {
+	_, found := commonPasswords[strings.ToLower(password)]
+	return found
+}
+
+// validatePassword checks a new password against the password policy in the
+// configuration: its length, whether it is a common password, and whether it is the
+// user's email address.
+//
+// Parameters:
+//   - v: The validator to record errors in.
+//   - key: The name of the field holding the password.
+//   - password: The new password.
+//   - email: The email address of the user the password is for.
+func (app *application) validatePassword(v *validator, key, password, email string) {
+	policy := app.config.password
+
+	v.Check(utf8.RuneCountInString(password) >= policy.minLength, key,
+		"must be at least "+strconv.Itoa(policy.minLength)+" characters long")
+	// bcrypt only hashes the first 72 bytes, and refuses anything longer
+	v.Check(len(password) <= policy.maxLength, key,
+		"must not be more than "+strconv.Itoa(policy.maxLength)+" bytes long")
+	v.Check(!strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)), key,
+		"must not be the same as the email address")
+	if policy.rejectCommon {
+		v.Check(!commonPassword(password), key, "is too common")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidatePassword checks each rule of the password policy.
+func TestValidatePassword(t *testing.T) {
+	app := &application{}
+	app.config.password.minLength = 8
+	app.config.password.maxLength = 72
+	app.config.password.rejectCommon = true
+
+	tests := []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{"acceptable", "correct horse battery", true},
+		{"too short", "k7#pQz", false},
+		{"too long", strings.Repeat("x", 73), false},
+		{"common", "password123", false},
+		{"common in another case", "PassWord123", false},
+		{"same as email", "Reader@Example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newValidator()
+			app.validatePassword(v, "password", tt.password, "reader@example.com")
+
+			if v.Valid() != tt.valid {
+				t.Errorf("valid = %v; want %v (errors: %v)", v.Valid(), tt.valid, v.Errors)
+			}
+		})
+	}
+
+	// with the list switched off, only the other rules apply
+	app.config.password.rejectCommon = false
+	v := newValidator()
+	app.validatePassword(v, "password", "password123", "reader@example.com")
+	if !v.Valid() {
+		t.Errorf("common password refused with reject-common off: %v", v.Errors)
+	}
+}
+
+// TestCommonPasswordList checks that the embedded list was read, without its comments.
+func TestCommonPasswordList(t *testing.T) {
+	if len(commonPasswords) < 100 {
+		t.Fatalf("read %d common passwords; want the embedded list", len(commonPasswords))
+	}
+
+	for password := range commonPasswords {
+		if strings.HasPrefix(password, "#") || password != strings.ToLower(password) {
+			t.Errorf("unexpected entry %q", password)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// normalizeEmail trims an email address and folds it to lower case, so that the same
+// address is stored the same way however it was typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// registeredMessage is the response to every valid registration, whether it created an
+// account or the email address was already registered, so that the endpoint cannot be
+// used to find out which addresses are registered.
+const registeredMessage = "Please check your email to finish creating your account"
+
+// Register is the handler used by anyone to create an account for themselves. The new
+// user is never an administrator, and is sent an email asking them to verify their
+// address; they sign in once the account exists, just as any other user. If the address
+// is already registered, its owner is emailed instead, and the response is the same, so
+// that it does not tell anyone which addresses are registered.
+//
+// It expects a JSON object with the following fields:
+//   - email: The email address of the new user.
+//   - first_name: The first name of the new user.
+//   - last_name: The last name of the new user.
+//   - password: The password of the new user, which must satisfy the password policy.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) Register(w http.ResponseWriter, r *http.Request) {
+	if !app.config.registration.enabled {
+		app.errorJson(w, errors.New("registration is closed"), http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	input.Email = normalizeEmail(input.Email)
+	input.FirstName = strings.TrimSpace(input.FirstName)
+	input.LastName = strings.TrimSpace(input.LastName)
+
+	v := newValidator()
+	v.Check(validEmail(input.Email), "email", "must be a valid email address")
+	v.Check(notBlank(input.FirstName), "first_name", "must be provided")
+	v.Check(notBlank(input.LastName), "last_name", "must be provided")
+	app.validatePassword(v, "password", input.Password, input.Email)
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	existing, err := app.models.Primary().User.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		// spend the time hashing the password would have taken
+		data.DummyPasswordCheck(input.Password)
+		app.background(func() { app.sendAccountExistsEmail(existing) })
+	case errors.Is(err, sql.ErrNoRows):
+		err = app.registerUser(input.Email, input.FirstName, input.LastName, input.Password)
+		if err != nil {
+			app.errorJson(w, err, http.StatusInternalServerError)
+			return
+		}
+	default:
+		app.errorLog.Println("error fetching user:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: registeredMessage,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// registerUser creates an account and sends its verification email. If another
+// registration for the same address won the race to create it, the owner of that account
+// is emailed instead, just as if it had existed all along.
+//
+// Parameters:
+//   - email: The normalized email address of the new user.
+//   - firstName: The first name of the new user.
+//   - lastName: The last name of the new user.
+//   - password: The password of the new user.
+//
+// Returns:
+//   - An error if the account could not be created, already logged.
+func (app *application) registerUser(email, firstName, lastName, password string) error {
+	id, err := app.models.User.Insert(data.User{
+		Email:     email,
+		FirstName: firstName,
+		LastName:  lastName,
+		Password:  password,
+	})
+	if err != nil && strings.Contains(err.Error(), "SQLSTATE 23505") {
+		existing, err := app.models.Primary().User.GetByEmail(email)
+		if err != nil {
+			app.errorLog.Println("error fetching user:", err)
+			return err
+		}
+
+		app.background(func() { app.sendAccountExistsEmail(existing) })
+		return nil
+	}
+	if err != nil {
+		app.errorLog.Println("error inserting user:", err)
+		return err
+	}
+
+	user, err := app.models.Primary().User.GetOne(id)
+	if err != nil {
+		app.errorLog.Println("error fetching user:", err)
+		return err
+	}
+
+	app.background(func() { app.sendVerificationEmail(user) })
+
+	return nil
+}
+
+// sendAccountExistsEmail tells the owner of an account that someone tried to register
+// with their address, and links to the front end, where they can sign in or reset their
+// password. It is meant to be run with app.background.
+func (app *application) sendAccountExistsEmail(user *data.User) {
+	err := app.mailer.Send(user.Email, "account_exists.tmpl", map[string]any{
+		"FirstName": user.FirstName,
+		"Link":      strings.TrimRight(app.config.frontendURL, "/") + "/",
+	})
+	if err != nil {
+		app.errorLog.Println("error sending account exists email:", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/polyglotdev/vue-api/internal/data"
+	"github.com/polyglotdev/vue-api/internal/mailer"
+)
+
+// TestRegisterExistingEmail checks that registering an address that is already taken
+// gets the same response as a new registration, and emails the owner of the account.
+func TestRegisterExistingEmail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fake := &mailer.Fake{}
+	app := &application{
+		models:   data.New(db),
+		mailer:   fake,
+		infoLog:  log.New(io.Discard, "", 0),
+		errorLog: log.New(io.Discard, "", 0),
+	}
+	app.config.registration.enabled = true
+	app.config.password.minLength = 8
+	app.config.password.maxLength = 72
+	app.config.frontendURL = "http://localhost:8080"
+
+	now := time.Now()
+	mock.ExpectQuery(`from users where lower\(email\) = lower\(\$1\)`).
+		WithArgs("reader@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "password", "is_admin", "verified_at", "created_at", "updated_at"}).
+			AddRow(7, "reader@example.com", "Ann", "Reader", "hash", false, now, now, now))
+
+	body := `{"email": " Reader@Example.com", "first_name": "Someone", "last_name": "Else", "password": "correct horse battery"}`
+	rr := httptest.NewRecorder()
+	app.Register(rr, httptest.NewRequest(http.MethodPost, "/users/register", strings.NewReader(body)))
+	app.wg.Wait()
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("status = %d; want %d", rr.Code, http.StatusAccepted)
+	}
+
+	var payload jsonResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Error || payload.Message != registeredMessage || payload.Data != nil {
+		t.Errorf("response = %s; want the one a new registration gets", rr.Body.String())
+	}
+
+	sent := fake.Sent()
+	if len(sent) != 1 || sent[0].To != "reader@example.com" || sent[0].Subject != "You already have an account" {
+		t.Errorf("sent %v; want one account exists email to the owner", sent)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// userRequest is the JSON body accepted when creating a user. It is the only type that
+// carries a plain text password, and it is never written back to the client.
+type userRequest struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+	IsAdmin   bool   `json:"is_admin"`
+}
+
+// userResponse is the public representation of a user. Handlers send this, never a
+// data.User, so that fields such as the password hash cannot leak by accident.
+type userResponse struct {
+	ID         int        `json:"id"`
+	Email      string     `json:"email"`
+	FirstName  string     `json:"first_name,omitempty"`
+	LastName   string     `json:"last_name,omitempty"`
+	IsAdmin    bool       `json:"is_admin"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// newUserResponse builds the public representation of a user.
+func newUserResponse(u *data.User) userResponse {
+	return userResponse{
+		ID:         u.ID,
+		Email:      u.Email,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		IsAdmin:    u.IsAdmin,
+		VerifiedAt: u.VerifiedAt,
+		CreatedAt:  u.CreatedAt,
+		UpdatedAt:  u.UpdatedAt,
+	}
+}
+
+// newUserResponses builds the public representation of a list of users.
+func newUserResponses(users []*data.User) []userResponse {
+	out := make([]userResponse, 0, len(users))
+	for _, u := range users {
+		out = append(out, newUserResponse(u))
+	}
+
+	return out
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// TestResponsesNeverContainPasswordHash marshals every payload a handler sends back to the
+// client, through writeJSON, and fails if a password hash or password field appears.
+// TestResponseTypesHaveNoSecretFields covers types that are not listed here.
+func TestResponsesNeverContainPasswordHash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &data.User{
+		ID:        1,
+		Email:     "admin@example.com",
+		FirstName: "Admin",
+		LastName:  "User",
+		Password:  string(hash),
+		IsAdmin:   true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	token := &data.Token{
+		ID:        1,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Token:     "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		TokenHash: []byte("hash"),
+		Scope:     data.ScopeAuthentication,
+		Expiry:    time.Now().Add(time.Hour),
+	}
+
+	author := data.Author{ID: 1, AuthorName: "Ursula K. Le Guin", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	genre := &data.Genre{ID: 1, GenreName: "Science Fiction", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	book := &data.Book{ID: 1, Title: "The Dispossessed", Slug: "the-dispossessed", Author: author, Genres: []*data.Genre{genre}}
+	attempt := &data.LoginAttempt{ID: 1, Email: user.Email, UserID: &user.ID, IPAddress: "192.0.2.1", Reason: data.LoginFailedCredentials}
+
+	responses := map[string]any{
+		"user":           newUserResponse(user),
+		"users":          newUserResponses([]*data.User{user, user}),
+		"session":        session{Token: token, Current: true},
+		"token":          token,
+		"model":          user,
+		"model list":     []*data.User{user},
+		"login":          jsonResponse{Data: envelope{"token": token, "refresh_token": token}},
+		"user envelope":  jsonResponse{Data: envelope{"user": newUserResponse(user)}},
+		"book":           jsonResponse{Data: envelope{"book": book}},
+		"books":          jsonResponse{Data: envelope{"books": []*data.Book{book}, "metadata": data.Metadata{PageSize: 20}}},
+		"cover":          jsonResponse{Data: envelope{"book": book, "cover_url": "/covers/1.jpg"}},
+		"search":         jsonResponse{Data: envelope{"results": []*data.SearchResult{{Kind: data.SearchKindBook, ID: 1, Title: book.Title}}}},
+		"login attempts": jsonResponse{Data: envelope{"login_attempts": []*data.LoginAttempt{attempt}}},
+		"error":          jsonResponse{Error: true, Message: "validation failed", Data: envelope{"errors": map[string]string{"email": "must be provided"}}},
+	}
+
+	app := &application{}
+
+	for name, response := range responses {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			err := app.writeJSON(rr, 200, response)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := rr.Body.String()
+			if strings.Contains(body, string(hash)) {
+				t.Errorf("response contains the password hash: %s", body)
+			}
+
+			var decoded any
+			err = json.Unmarshal(rr.Body.Bytes(), &decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key := findKey(decoded, "password"); key {
+				t.Errorf("response contains a password field: %s", body)
+			}
+		})
+	}
+}
+
+// findKey reports whether key appears as an object key anywhere in a decoded JSON value.
+func findKey(v any, key string) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if k == key || findKey(child, key) {
+				return true
+			}
+		}
+	case []any:
+		for _, child := range v {
+			if findKey(child, key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requestTypes lists the types that are only ever decoded from requests, and so may carry
+// a plain text password.
+var requestTypes = map[string]bool{
+	"userRequest": true,
+	"credentials": true,
+}
+
+// TestResponseTypesHaveNoSecretFields reads every struct type declared in this package and
+// in the data package, so that types added later are covered too, and fails if an
+// exported field that looks like a password or a hash would be marshalled to JSON.
+func TestResponseTypesHaveNoSecretFields(t *testing.T) {
+	fset := token.NewFileSet()
+
+	for _, dir := range []string{".", "../../internal/data"} {
+		pkgs, err := parser.ParseDir(fset, dir, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, pkg := range pkgs {
+			ast.Inspect(pkg, func(n ast.Node) bool {
+				spec, ok := n.(*ast.TypeSpec)
+				if !ok || requestTypes[spec.Name.Name] {
+					return true
+				}
+
+				st, ok := spec.Type.(*ast.StructType)
+				if !ok {
+					return true
+				}
+
+				for _, field := range st.Fields.List {
+					for _, name := range field.Names {
+						if !name.IsExported() {
+							continue
+						}
+
+						var jsonName string
+						if field.Tag != nil {
+							tag, _ := strconv.Unquote(field.Tag.Value)
+							jsonName, _, _ = strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+						}
+						if jsonName == "-" {
+							continue
+						}
+
+						lower := strings.ToLower(name.Name + " " + jsonName)
+						if strings.Contains(lower, "password") || strings.Contains(lower, "hash") {
+							t.Errorf("%s: %s.%s is marshalled to JSON; tag it json:\"-\"",
+								fset.Position(field.Pos()), spec.Name.Name, name.Name)
+						}
+					}
+				}
+
+				return true
+			})
+		}
+	}
+}
@@ -1,14 +1,12 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-
-	"github.com/polyglotdev/vue-api/internal/data"
 )
 
 // routes generates our routes and attaches them to handlers, using the chi router
@@ -19,7 +17,7 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 	mux.Use(middleware.Recoverer)
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedOrigins:   app.config.corsOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
@@ -27,128 +25,65 @@ func (app *application) routes() http.Handler {
 		MaxAge:           300,
 	}))
 
+	// public routes
 	mux.Get("/users/login", app.Login)
 	mux.Post("/users/login", app.Login)
-
-	mux.Get("/users/all", func(w http.ResponseWriter, r *http.Request) {
-		var users data.User
-		all, err := users.GetAll()
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-
-		payload := jsonResponse{
-			Error:   false,
-			Message: "All users retrieved",
-			Data:    envelope{"users": all},
-		}
-
-		err = app.writeJSON(w, http.StatusOK, payload)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-	})
-
-	mux.Get("/users/add", func(w http.ResponseWriter, r *http.Request) {
-		var u = data.User{
-			Email:     "dmitri@polyglot.dev",
-			FirstName: "Dmitri",
-			LastName:  "Johnson",
-			Password:  "password",
-		}
-
-		app.infoLog.Println("Adding user...")
-
-		id, err := app.models.User.Insert(u)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-
-		app.infoLog.Println("Got User ID of: ", id)
-		newUser, _ := app.models.User.GetOne(id)
-		err = app.writeJSON(w, http.StatusOK, newUser)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-	})
-
-	mux.Get("/test-generate-token", func(w http.ResponseWriter, r *http.Request) {
-		token, err := app.models.User.Token.GenerateToken(2, 60*time.Minute)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-
-		token.Email = "you@there.com"
-		token.CreatedAt = time.Now()
-		token.UpdatedAt = time.Now()
-
-		payload := jsonResponse{
-			Error:   false,
-			Message: "Token generated",
-			Data:    token,
-		}
-
-		if err = app.writeJSON(w, http.StatusOK, payload); err != nil {
-			app.errorLog.Println("Error while writing JSON response:", err)
-		}
+	mux.Post("/users/register", app.Register)
+	mux.Post("/users/logout", app.Logout)
+	mux.Post("/users/refresh", app.Refresh)
+	mux.Post("/users/forgot-password", app.ForgotPassword)
+	mux.Post("/users/reset-password", app.ResetPassword)
+	mux.Get("/users/verify", app.VerifyEmail)
+	mux.Post("/users/verify/resend", app.ResendVerification)
+
+	// the book catalog may be read anonymously; changes to it require authentication
+	mux.Get("/books", app.AllBooks)
+	mux.Get("/books/{slug}", app.OneBook)
+	mux.Get("/authors/{id}/books", app.AuthorBooks)
+	mux.Get("/genres/{id}/books", app.GenreBooks)
+	mux.Get("/covers/*", app.ServeCover)
+	mux.Get("/search", app.SearchCatalog)
+
+	// protected routes; everything in this group requires a valid bearer token
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.AuthTokenMiddleware)
+
+		app.protectedRoutes(mux)
 	})
 
-	mux.Get("/test-save-token", func(w http.ResponseWriter, r *http.Request) {
-		token, err := app.models.User.Token.GenerateToken(2, 60*time.Minute)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-
-		user, err := app.models.User.GetOne(2)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-
-		token.UserID = user.ID
-		token.CreatedAt = time.Now()
-		token.UpdatedAt = time.Now()
-		app.infoLog.Println("Your token is: ", token.Token)
-
-		err = token.Insert(*token, *user)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-
-		payload := jsonResponse{
-			Error:   false,
-			Message: "Token generated",
-			Data:    token,
-		}
+	return mux
+}
 
-		if err = app.writeJSON(w, http.StatusOK, payload); err != nil {
-			app.errorLog.Println("Error while writing JSON response:", err)
-		}
+// protectedRoutes registers the routes that may only be reached by an authenticated user.
+// It is called from routes inside a group that has AuthTokenMiddleware applied.
+func (app *application) protectedRoutes(mux chi.Router) {
+	mux.Post("/users/logout-all", app.LogoutAll)
+	mux.Get("/users/sessions", app.Sessions)
+	mux.Delete("/users/sessions/{id}", app.RevokeSession)
+
+	mux.With(app.RequireAdmin).Post("/users", app.CreateUser)
+	mux.Get("/users/{id}", app.GetUser)
+	mux.Put("/users/{id}", app.UpdateUser)
+	mux.Delete("/users/{id}", app.DeleteUser)
+	mux.Put("/users/{id}/password", app.ChangePassword)
+
+	// administrator routes
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(app.RequireAdmin)
+
+		mux.Post("/books", app.CreateBook)
+		mux.Put("/books/{id}", app.UpdateBook)
+		mux.Delete("/books/{id}", app.DeleteBook)
+		mux.Post("/books/{id}/cover", app.UploadCover)
+
+		// account lockout and the audit trail of failed sign ins
+		mux.Post("/users/{id}/unlock", app.UnlockUser)
+		mux.Get("/users/{id}/login-attempts", app.UserLoginAttempts)
+
+		// runtime and connection pool metrics, published with expvar
+		mux.Get("/debug/vars", expvar.Handler().ServeHTTP)
 	})
 
-	mux.Get("/test-validate-token", func(w http.ResponseWriter, r *http.Request) {
-		tokenToValidate := r.URL.Query().Get("token")
-		valid, err := app.models.User.Token.ValidToken(tokenToValidate)
-		if err != nil {
-			app.errorLog.Println(err)
-			return
-		}
-
-		var payload jsonResponse
-		payload.Error = !valid
-		payload.Message = "Token is valid"
-		payload.Data = valid
-
-		if err = app.writeJSON(w, http.StatusOK, payload); err != nil {
-			app.errorLog.Println("Error while writing JSON response:", err)
-		}
-	})
-	return mux
+	// listing every user, with their email addresses, is for administrators only
+	mux.With(app.RequireAdmin).Get("/users/all", app.AllUsers)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SearchCatalog is the handler used to search books and authors together, ranked by
+// relevance.
+//
+// It accepts the following query string parameters:
+//   - q: The search query, in web search syntax. Required.
+//   - limit: The maximum number of results, up to 50. Defaults to 20.
+//
+// It returns a JSON response whose data holds the results, and a fuzzy flag which is true
+// if nothing matched exactly and the results come from typo tolerant matching instead.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) SearchCatalog(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := newValidator()
+
+	q := strings.TrimSpace(qs.Get("q"))
+	limit := app.readInt(qs, "limit", 20, v)
+
+	v.Check(notBlank(q), "q", "must be provided")
+	v.Check(len(q) <= 255, "q", "must not be more than 255 characters long")
+	v.Check(limit > 0 && limit <= 50, "limit", "must be between 1 and 50")
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	results, fuzzy, err := app.models.Search.Query(q, limit, app.config.searchFuzzy)
+	if err != nil {
+		app.errorLog.Println("error searching:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Search complete",
+		Data:    envelope{"results": results, "fuzzy": fuzzy},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
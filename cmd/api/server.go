@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// serve starts the web server, and blocks until it has been shut down by SIGINT or SIGTERM.
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		ReadTimeout:  app.config.http.readTimeout,
+		WriteTimeout: app.config.http.writeTimeout,
+		IdleTimeout:  app.config.http.idleTimeout,
+		ErrorLog:     app.errorLog,
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	app.infoLog.Printf("API listening on port %d\n", app.config.port)
+
+	return app.run(srv, ln)
+}
+
+// run serves requests on ln until the process receives SIGINT or SIGTERM, and then shuts
+// down in order: the server stops accepting connections and waits, for up to the drain
+// timeout, for in-flight requests to finish; then background tasks are waited for; then
+// the mailer's workers are stopped; and finally the database pools are closed, since
+// everything before them may still need them.
+//
+// Parameters:
+//   - srv: The server to run.
+//   - ln: The listener to accept connections on.
+//
+// Returns:
+//   - An error if the server failed, or did not shut down cleanly.
+func (app *application) run(srv *http.Server, ln net.Listener) error {
+	// register for signals before serving, so none can arrive unhandled
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	shutdownError := make(chan error, 1)
+
+	go func() {
+		s := <-quit
+		app.infoLog.Printf("Caught signal %s; draining connections for up to %s\n", s, app.config.drainTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.drainTimeout)
+		defer cancel()
+
+		shutdownError <- srv.Shutdown(ctx)
+	}()
+
+	err := srv.Serve(ln)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		app.errorLog.Println("Error draining connections:", err)
+	} else {
+		app.infoLog.Println("Connections drained")
+	}
+
+	app.infoLog.Println("Waiting for background tasks to finish")
+	app.wg.Wait()
+	app.infoLog.Println("Background tasks finished")
+
+	// background tasks may have queued mail, so the mailer is stopped after them; mail
+	// that has not been delivered yet stays in the outbox until the next start
+	if queue, ok := app.mailer.(interface{ Stop(context.Context) error }); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.drainTimeout)
+		stopErr := queue.Stop(ctx)
+		cancel()
+
+		if stopErr != nil {
+			app.errorLog.Println("Error stopping mailer:", stopErr)
+			err = errors.Join(err, stopErr)
+		} else {
+			app.infoLog.Println("Mailer stopped")
+		}
+	}
+
+	if app.db != nil {
+		closeErr := app.db.Close()
+		if closeErr != nil {
+			app.errorLog.Println("Error closing database pools:", closeErr)
+			err = errors.Join(err, closeErr)
+		} else {
+			app.infoLog.Println("Database pools closed")
+		}
+	}
+
+	app.infoLog.Println("Server stopped")
+
+	return err
+}
+
+// background runs fn in a goroutine that shutdown waits for, recovering and logging any
+// panic so that a failed background task cannot bring down the server.
+//
+// Parameters:
+//   - fn: The function to run.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Println("panic in background task:", err)
+			}
+		}()
+
+		fn()
+	}()
+}
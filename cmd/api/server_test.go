@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestShutdownDrainsInFlightRequests checks that a request which is still being handled
+// when SIGTERM arrives is allowed to finish, and that run then returns cleanly.
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		_, _ = io.WriteString(w, "done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		config:   config{drainTimeout: 5 * time.Second},
+		infoLog:  log.New(io.Discard, "", 0),
+		errorLog: log.New(io.Discard, "", 0),
+	}
+
+	backgroundDone := false
+	app.background(func() {
+		<-started
+		time.Sleep(100 * time.Millisecond)
+		backgroundDone = true
+	})
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- app.run(&http.Server{Handler: handler}, ln)
+	}()
+
+	type response struct {
+		status int
+		body   string
+		err    error
+	}
+	responses := make(chan response, 1)
+
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		responses <- response{status: res.StatusCode, body: string(body), err: err}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the handler")
+	}
+
+	err = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-responses
+	if res.err != nil {
+		t.Fatalf("in-flight request failed: %v", res.err)
+	}
+	if res.status != http.StatusOK || res.body != "done" {
+		t.Fatalf("got %d %q; want 200 \"done\"", res.status, res.body)
+	}
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("run returned %v; want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after shutdown")
+	}
+
+	if !backgroundDone {
+		t.Error("run returned before background tasks finished")
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// canManageUser reports whether the authenticated user may read or change the user with
+// the given id. Users may manage themselves; administrators may manage anyone.
+func (app *application) canManageUser(r *http.Request, id int) bool {
+	user := app.contextGetUser(r)
+	return user.IsAdmin || user.ID == id
+}
+
+// fetchUser looks up the user named by the "id" URL parameter, writing an error response
+// and returning nil if the id is invalid, the caller may not manage that user, or no such
+// user exists. For anything but a GET request, the user is read from the primary database.
+func (app *application) fetchUser(w http.ResponseWriter, r *http.Request) *data.User {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJson(w, err)
+		return nil
+	}
+
+	if !app.canManageUser(r, id) {
+		app.errorJson(w, errors.New("you do not have permission to access this resource"), http.StatusForbidden)
+		return nil
+	}
+
+	// a user about to be changed is read from the primary, which replicas may lag behind
+	models := &app.models
+	if r.Method != http.MethodGet {
+		models = models.Primary()
+	}
+
+	user, err := models.User.GetOne(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJson(w, errors.New("user not found"), http.StatusNotFound)
+			return nil
+		}
+
+		app.errorLog.Println("error fetching user:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return nil
+	}
+
+	return user
+}
+
+// AllUsers is the handler used by administrators to list users, one page at a time.
+//
+// It accepts the following query string parameters:
+//   - page: The page to return, starting from 1. Defaults to 1.
+//   - page_size: The number of users per page, up to 100. Defaults to 20.
+//   - sort: The column to sort by, optionally prefixed with "-" for descending order.
+//     One of id, email, first_name, last_name or created_at. Defaults to last_name.
+//   - q: An optional search term, matched against names and email addresses.
+//   - cursor: The next_cursor value from a previous page. When set, page is ignored and
+//     the page after the cursor is returned, which stays fast however deep it is; the
+//     metadata then leaves out total_records.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) AllUsers(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := newValidator()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "last_name"),
+		SortSafelist: data.UserSortSafelist,
+		Query:        strings.TrimSpace(qs.Get("q")),
+		Cursor:       qs.Get("cursor"),
+	}
+
+	if validateFilters(v, filters); !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	users, metadata, err := app.models.User.GetAll(filters)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			app.errorJson(w, err)
+			return
+		}
+
+		app.errorLog.Println("error fetching users:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "All users retrieved",
+		Data:    envelope{"users": newUserResponses(users), "metadata": metadata},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// CreateUser is the handler used by administrators to add a new user. The user is sent
+// an email asking them to verify their address.
+//
+// It expects a JSON object with the following fields:
+//   - email: The email address of the new user.
+//   - first_name: The first name of the new user.
+//   - last_name: The last name of the new user.
+//   - password: The password of the new user.
+//   - is_admin: Whether the new user is an administrator.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var input userRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	input.Email = normalizeEmail(input.Email)
+
+	v := newValidator()
+	v.Check(validEmail(input.Email), "email", "must be a valid email address")
+	v.Check(notBlank(input.FirstName), "first_name", "must be provided")
+	v.Check(notBlank(input.LastName), "last_name", "must be provided")
+	app.validatePassword(v, "password", input.Password, input.Email)
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	id, err := app.models.User.Insert(data.User{
+		Email:     input.Email,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Password:  input.Password,
+		IsAdmin:   input.IsAdmin,
+	})
+	if err != nil {
+		app.errorLog.Println("error inserting user:", err)
+		app.errorJson(w, err)
+		return
+	}
+
+	user, err := app.models.Primary().User.GetOne(id)
+	if err != nil {
+		app.errorLog.Println("error fetching user:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.background(func() { app.sendVerificationEmail(user) })
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User created",
+		Data:    envelope{"user": newUserResponse(user)},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// GetUser is the handler used to fetch a single user by id.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) GetUser(w http.ResponseWriter, r *http.Request) {
+	user := app.fetchUser(w, r)
+	if user == nil {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User retrieved",
+		Data:    envelope{"user": newUserResponse(user)},
+	}
+
+	err := app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// UpdateUser is the handler used to change a user's details. Only administrators may
+// change the is_admin field; it is ignored for everyone else. Changing the email address
+// marks it unverified, and sends a verification email to the new address.
+//
+// It expects a JSON object with the following fields:
+//   - email: The new email address of the user.
+//   - first_name: The new first name of the user.
+//   - last_name: The new last name of the user.
+//   - is_admin: Optionally, whether the user is an administrator.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user := app.fetchUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		IsAdmin   *bool  `json:"is_admin"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	input.Email = normalizeEmail(input.Email)
+
+	v := newValidator()
+	v.Check(validEmail(input.Email), "email", "must be a valid email address")
+	v.Check(notBlank(input.FirstName), "first_name", "must be provided")
+	v.Check(notBlank(input.LastName), "last_name", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	// a new email address has to be verified again
+	emailChanged := !strings.EqualFold(user.Email, input.Email)
+	if emailChanged {
+		user.VerifiedAt = nil
+	}
+
+	user.Email = input.Email
+	user.FirstName = input.FirstName
+	user.LastName = input.LastName
+	if input.IsAdmin != nil && app.contextGetUser(r).IsAdmin {
+		user.IsAdmin = *input.IsAdmin
+	}
+
+	err = user.Update()
+	if err != nil {
+		app.errorLog.Println("error updating user:", err)
+		app.errorJson(w, err)
+		return
+	}
+
+	if emailChanged {
+		app.background(func() { app.sendVerificationEmail(user) })
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User updated",
+		Data:    envelope{"user": newUserResponse(user)},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// DeleteUser is the handler used to delete a user, along with all of their sessions.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user := app.fetchUser(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.models.Token.DeleteByUserID(user.ID)
+	if err != nil {
+		app.errorLog.Println("error deleting tokens:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = user.Delete()
+	if err != nil {
+		app.errorLog.Println("error deleting user:", err)
+		app.errorJson(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User deleted",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// ChangePassword is the handler used to set a new password for a user. Users changing
+// their own password must also supply their current one; administrators need not. Every
+// session the user has is then signed out, except the one making the change.
+//
+// It expects a JSON object with the following fields:
+//   - current_password: The user's current password.
+//   - password: The new password.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := app.fetchUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	v := newValidator()
+	app.validatePassword(v, "password", input.Password, user.Email)
+	if !app.contextGetUser(r).IsAdmin {
+		matches, err := user.PasswordMatches(input.CurrentPassword)
+		v.Check(err == nil && matches, "current_password", "is incorrect")
+	}
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	err = user.ResetPassword(input.Password)
+	if err != nil {
+		app.errorLog.Println("error resetting password:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.revokeOtherSessions(r, user)
+	if err != nil {
+		app.errorLog.Println("error deleting tokens:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password changed",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// revokeOtherSessions signs a user out of every session except the one the request was
+// made with, if it is theirs; when an administrator acts on someone else, every one of that
+// user's sessions is signed out.
+//
+// Parameters:
+//   - r: The authenticated HTTP request.
+//   - user: The user whose sessions should be revoked.
+//
+// Returns:
+//   - An error if the sessions could not be revoked.
+func (app *application) revokeOtherSessions(r *http.Request, user *data.User) error {
+	if app.contextGetUser(r).ID != user.ID {
+		return app.models.Token.DeleteByUserID(user.ID)
+	}
+
+	plainText, err := data.BearerToken(r)
+	if err != nil {
+		return err
+	}
+
+	current, err := app.models.Token.GetByToken(plainText)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Token.DeleteOthersForUser(user.ID, current.FamilyID)
+}
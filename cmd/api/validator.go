@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// emailRX is a deliberately loose check for something shaped like an email address.
+// The only real proof that an address works is sending mail to it.
+var emailRX = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// validator collects field-level validation errors for a request.
+type validator struct {
+	// Errors maps the name of each invalid field to a description of the problem.
+	Errors map[string]string
+}
+
+// newValidator returns a validator with no errors.
+func newValidator() *validator {
+	return &validator{Errors: make(map[string]string)}
+}
+
+// Valid reports whether no errors have been recorded.
+func (v *validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Check records an error for key if ok is false. Only the first error for each key is kept.
+//
+// Parameters:
+//   - ok: The result of the check.
+//   - key: The name of the field being checked.
+//   - message: A description of the problem, used if the check failed.
+func (v *validator) Check(ok bool, key, message string) {
+	if ok {
+		return
+	}
+
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+	}
+}
+
+// validEmail reports whether email looks like an email address.
+func validEmail(email string) bool {
+	return len(email) <= 255 && emailRX.MatchString(email)
+}
+
+// notBlank reports whether value contains anything other than whitespace.
+func notBlank(value string) bool {
+	return strings.TrimSpace(value) != ""
+}
+
+// permittedValue reports whether value is one of permittedValues.
+func permittedValue(value string, permittedValues ...string) bool {
+	for _, permitted := range permittedValues {
+		if value == permitted {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateFilters checks the pagination, sorting and search options in f.
+//
+// Parameters:
+//   - v: The validator to record errors in.
+//   - f: The filters to check.
+func validateFilters(v *validator, f data.Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(permittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(len(f.Query) <= 255, "q", "must not be more than 255 characters long")
+}
+
+// failedValidation sends a 422 response listing every validation error in v.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - v: The validator holding the errors.
+func (app *application) failedValidation(w http.ResponseWriter, v *validator) {
+	payload := jsonResponse{
+		Error:   true,
+		Message: "validation failed",
+		Data:    envelope{"errors": v.Errors},
+	}
+
+	_ = app.writeJSON(w, http.StatusUnprocessableEntity, payload)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/polyglotdev/vue-api/internal/data"
+)
+
+// resendVerificationMessage is the response to every valid request for another
+// verification email, whether or not it was sent, so that the endpoint cannot be used to
+// find out which addresses are registered or verified.
+const resendVerificationMessage = "If that email address is registered and not yet verified, a new verification link has been sent to it"
+
+// sendVerificationEmail emails a user a link that verifies their email address, unless
+// they are already verified or were sent one too recently. It is meant to be run with
+// app.background.
+func (app *application) sendVerificationEmail(user *data.User) {
+	ok, err := user.ReserveVerificationEmail(app.config.verification.resendInterval)
+	if err != nil {
+		app.errorLog.Println("error recording verification email:", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	token := user.VerificationToken([]byte(app.config.signingKey), app.config.verification.ttl)
+	link := strings.TrimRight(app.config.publicURL, "/") + "/users/verify?token=" + url.QueryEscape(token)
+
+	err = app.mailer.Send(user.Email, "verify_email.tmpl", map[string]any{
+		"FirstName": user.FirstName,
+		"Link":      link,
+		"TTL":       app.config.verification.ttl,
+	})
+	if err != nil {
+		app.errorLog.Println("error sending verification email:", err)
+	}
+}
+
+// VerifyEmail is the handler for the link in a verification email. It marks the user's
+// email address as verified. Each link works once.
+//
+// It accepts the following query string parameters:
+//   - token: The token from the verification link.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	v := newValidator()
+	v.Check(notBlank(token), "token", "must be provided")
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	user, err := app.models.User.GetForVerification(token, []byte(app.config.signingKey))
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidVerificationToken) {
+			v.Check(false, "token", "is invalid, has expired, or has already been used")
+			app.failedValidation(w, v)
+			return
+		}
+
+		app.errorLog.Println("error checking verification token:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = user.Verify()
+	if err != nil {
+		app.errorLog.Println("error verifying user:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Email address verified",
+		Data:    envelope{"user": newUserResponse(user)},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
+
+// ResendVerification is the handler used to ask for another verification email. A user
+// is sent at most one every verification.resend-interval; the response is the same
+// whether or not an email was sent.
+//
+// It expects a JSON object with the following fields:
+//   - email: The email address to verify.
+//
+// Parameters:
+//   - w: The HTTP response writer.
+//   - r: The HTTP request.
+func (app *application) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	input.Email = normalizeEmail(input.Email)
+
+	v := newValidator()
+	v.Check(validEmail(input.Email), "email", "must be a valid email address")
+	if !v.Valid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	user, err := app.models.User.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		app.background(func() { app.sendVerificationEmail(user) })
+	case !errors.Is(err, sql.ErrNoRows):
+		app.errorLog.Println("error fetching user:", err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: resendVerificationMessage,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.errorLog.Println("Error while writing JSON response:", err)
+	}
+}
@@ -0,0 +1,816 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Author represents an author in the database.
+type Author struct {
+	// ID is the primary key for the author.
+	ID int `json:"id"`
+	// AuthorName is the full name of the author.
+	AuthorName string `json:"author_name"`
+	// CreatedAt is the time the author was created.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is the time the author was last updated.
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// primary sends this model's reads to the primary database; see Models.Primary.
+	primary bool
+}
+
+// Genre represents a genre in the database.
+type Genre struct {
+	// ID is the primary key for the genre.
+	ID int `json:"id"`
+	// GenreName is the name of the genre.
+	GenreName string `json:"genre_name"`
+	// CreatedAt is the time the genre was created.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is the time the genre was last updated.
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// primary sends this model's reads to the primary database; see Models.Primary.
+	primary bool
+}
+
+// Book represents a book in the database, along with its author and genres.
+type Book struct {
+	// ID is the primary key for the book.
+	ID int `json:"id"`
+	// Title is the title of the book.
+	Title string `json:"title"`
+	// AuthorID is the foreign key for the author.
+	AuthorID int `json:"author_id"`
+	// PublicationYear is the year the book was published.
+	PublicationYear int `json:"publication_year"`
+	// Slug is the unique, URL friendly identifier for the book.
+	Slug string `json:"slug"`
+	// Description is a description of the book.
+	Description string `json:"description"`
+	// CoverPath is the storage key of the book's cover image, or "" if it has none. The
+	// thumbnail is stored alongside it, at ThumbnailPath(CoverPath).
+	CoverPath string `json:"cover_path,omitempty"`
+	// CreatedAt is the time the book was created.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is the time the book was last updated.
+	UpdatedAt time.Time `json:"updated_at"`
+	// Author is the author of the book.
+	Author Author `json:"author"`
+	// Genres is the list of genres the book belongs to.
+	Genres []*Genre `json:"genres"`
+
+	// primary sends this model's reads to the primary database; see Models.Primary.
+	primary bool
+}
+
+// bookColumns is the list of columns selected whenever a full book is read, with its
+// author joined in and its genres aggregated into a JSON array, so a book is always
+// loaded in a single query. Genre timestamps are formatted as RFC 3339 so that they
+// can be decoded into time.Time. It matches the order in which scanBook reads them, and
+// must be used with bookJoins.
+const bookColumns = `b.id, coalesce(b.title, ''), coalesce(b.author_id, 0), coalesce(b.publication_year, 0),
+	coalesce(b.slug, ''), coalesce(b.description, ''), coalesce(b.cover_path, ''),
+	coalesce(b.created_at, 'epoch'), coalesce(b.updated_at, 'epoch'),
+	coalesce(a.id, 0), coalesce(a.author_name, ''),
+	coalesce(a.created_at, 'epoch'), coalesce(a.updated_at, 'epoch'),
+	coalesce((
+		select json_agg(json_build_object(
+			'id', g.id,
+			'genre_name', coalesce(g.genre_name, ''),
+			'created_at', to_char(coalesce(g.created_at, 'epoch'), 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+			'updated_at', to_char(coalesce(g.updated_at, 'epoch'), 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+		) order by g.genre_name)
+		from books_genres bg
+		join genres g on g.id = bg.genre_id
+		where bg.book_id = b.id
+	), '[]')`
+
+// bookJoins is the from clause that goes with bookColumns.
+const bookJoins = `from books b left join authors a on a.id = b.author_id`
+
+// scanBook reads a single book, selected using bookColumns, from a row.
+func scanBook(row interface{ Scan(...any) error }) (*Book, error) {
+	var book Book
+	var genres []byte
+
+	err := row.Scan(
+		&book.ID,
+		&book.Title,
+		&book.AuthorID,
+		&book.PublicationYear,
+		&book.Slug,
+		&book.Description,
+		&book.CoverPath,
+		&book.CreatedAt,
+		&book.UpdatedAt,
+		&book.Author.ID,
+		&book.Author.AuthorName,
+		&book.Author.CreatedAt,
+		&book.Author.UpdatedAt,
+		&genres,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(genres, &book.Genres)
+	if err != nil {
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// BookSortSafelist is the list of values accepted as Filters.Sort when listing books.
+var BookSortSafelist = []string{
+	"id", "title", "publication_year", "created_at",
+	"-id", "-title", "-publication_year", "-created_at",
+}
+
+// BookFilters holds the options for listing books: the usual pagination and sorting,
+// plus filters on author, genre and publication year. Zero values disable a filter.
+// Keyset pagination is not supported for books, so Filters.Cursor is ignored.
+type BookFilters struct {
+	Filters
+	// AuthorID restricts the list to books by one author.
+	AuthorID int
+	// GenreIDs restricts the list to books in at least one of the genres.
+	GenreIDs []int
+	// YearFrom is the earliest publication year to include.
+	YearFrom int
+	// YearTo is the latest publication year to include.
+	YearTo int
+}
+
+// GetAll returns one page of books matching the filters, with their authors and genres,
+// along with the pagination metadata for the whole result set. Filters.Query matches
+// against the title; ties in the sort column are broken by id.
+//
+// Parameters:
+// - filters: BookFilters: the pagination, sorting and filtering options
+//
+// Returns:
+// - []*Book: a slice of type Book
+// - Metadata: the pagination metadata
+// - error: an error
+func (b *Book) GetAll(filters BookFilters) ([]*Book, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where := `($1 = 0 or b.author_id = $1)
+		and ($2 = 0 or b.publication_year >= $2)
+		and ($3 = 0 or b.publication_year <= $3)
+		and (cardinality($4::integer[]) = 0 or exists (
+			select 1 from books_genres bg where bg.book_id = b.id and bg.genre_id = any($4::integer[])
+		))
+		and ($5 = '' or b.title ilike $6)`
+
+	args := []any{
+		filters.AuthorID,
+		filters.YearFrom,
+		filters.YearTo,
+		intArray(filters.GenreIDs),
+		filters.Query,
+		filters.searchPattern(),
+	}
+
+	var totalRecords int
+	err := readDB(b.primary).QueryRowContext(ctx, `select count(*) from books b where `+where, args...).Scan(&totalRecords)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	query := fmt.Sprintf(`select %s %s where %s order by b.%s %s, b.id %s limit $7 offset $8`,
+		bookColumns, bookJoins, where, column, direction, direction)
+
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := readDB(b.primary).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		books = append(books, book)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	filters.Cursor = ""
+
+	return books, calculateMetadata(totalRecords, filters.Filters), nil
+}
+
+// intArray formats a slice of ints as a Postgres array literal, such as "{1,2,3}".
+func intArray(ints []int) string {
+	parts := make([]string, len(ints))
+	for i, n := range ints {
+		parts[i] = strconv.Itoa(n)
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// GetOne returns one book, with its author and genres, by id.
+//
+// Parameters:
+//
+// - id: int: the id of the book
+//
+// Returns:
+//
+// - *Book: a pointer to the Book model
+// - error: an error
+func (b *Book) GetOne(id int) (*Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + bookColumns + ` ` + bookJoins + ` where b.id = $1`
+
+	return scanBook(readDB(b.primary).QueryRowContext(ctx, query, id))
+}
+
+// GetOneBySlug returns one book, with its author and genres, by slug.
+//
+// Parameters:
+//
+// - slug: string: the slug of the book
+//
+// Returns:
+//
+// - *Book: a pointer to the Book model
+// - error: an error
+func (b *Book) GetOneBySlug(slug string) (*Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + bookColumns + ` ` + bookJoins + ` where b.slug = $1`
+
+	return scanBook(readDB(b.primary).QueryRowContext(ctx, query, slug))
+}
+
+// Slugify turns a title into a URL friendly slug: lower case letters and digits, with
+// every other run of characters replaced by a single hyphen.
+//
+// Parameters:
+//
+// - title: string: the title to convert
+//
+// Returns:
+//
+// - string: the slug
+func Slugify(title string) string {
+	var sb strings.Builder
+	hyphen := false
+
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			hyphen = false
+			continue
+		}
+
+		if !hyphen && sb.Len() > 0 {
+			sb.WriteRune('-')
+			hyphen = true
+		}
+	}
+
+	slug := strings.TrimSuffix(sb.String(), "-")
+	if slug == "" {
+		slug = "book"
+	}
+
+	return slug
+}
+
+// uniqueSlug returns a slug for title that no other book uses, by appending -2, -3 and so
+// on to the plain slug until it is free. The book with id excludeID is ignored, so that
+// a book keeps its own slug when updated. It takes a transaction level advisory lock on
+// the plain slug, so that concurrent transactions saving books with the same title wait
+// for each other instead of picking the same slug.
+func uniqueSlug(ctx context.Context, tx *sql.Tx, title string, excludeID int) (string, error) {
+	base := Slugify(title)
+
+	_, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock(hashtext($1))`, base)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`select slug from books where (slug = $1 or slug like $2) and id <> $3`,
+		base, base+"-%", excludeID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return "", err
+		}
+		taken[slug] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	slug := base
+	for i := 2; taken[slug]; i++ {
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	return slug, nil
+}
+
+// setGenres replaces the list of genres a book belongs to, inside a transaction.
+func setGenres(ctx context.Context, tx *sql.Tx, bookID int, genreIDs []int) error {
+	_, err := tx.ExecContext(ctx, `delete from books_genres where book_id = $1`, bookID)
+	if err != nil {
+		return err
+	}
+
+	for _, genreID := range genreIDs {
+		_, err = tx.ExecContext(ctx,
+			`insert into books_genres (book_id, genre_id, created_at, updated_at) values ($1, $2, $3, $4)`,
+			bookID, genreID, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Insert inserts a new book into the database, along with the links to its genres, in a
+// single transaction. A unique slug is generated from the title, and the ID and slug of
+// the new book are returned.
+//
+// Parameters:
+//
+// - book: Book: the book to insert
+// - genreIDs: []int: the ids of the genres the book belongs to
+//
+// Returns:
+//
+// - int: the id of the newly inserted row
+// - string: the slug of the newly inserted row
+// - error: an error
+func (b *Book) Insert(book Book, genreIDs []int) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	slug, err := uniqueSlug(ctx, tx, book.Title, 0)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var newID int
+	stmt := `insert into books (title, author_id, publication_year, slug, description, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		book.Title,
+		book.AuthorID,
+		book.PublicationYear,
+		slug,
+		book.Description,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = setGenres(ctx, tx, newID, genreIDs)
+	if err != nil {
+		return 0, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, "", err
+	}
+
+	return newID, slug, nil
+}
+
+// Update updates one book in the database, using the information stored in the receiver b,
+// and replaces the links to its genres, in a single transaction. If the title has changed,
+// a new unique slug is generated from it and stored in b.Slug.
+//
+// Parameters:
+//
+// - genreIDs: []int: the ids of the genres the book belongs to
+//
+// Returns:
+//
+// - error: an error
+func (b *Book) Update(genreIDs []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentTitle string
+	err = tx.QueryRowContext(ctx, `select coalesce(title, '') from books where id = $1 for update`, b.ID).Scan(&currentTitle)
+	if err != nil {
+		return err
+	}
+
+	if currentTitle != b.Title || b.Slug == "" {
+		b.Slug, err = uniqueSlug(ctx, tx, b.Title, b.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `update books set
+		title = $1,
+		author_id = $2,
+		publication_year = $3,
+		slug = $4,
+		description = $5,
+		updated_at = $6
+		where id = $7
+	`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		b.Title,
+		b.AuthorID,
+		b.PublicationYear,
+		b.Slug,
+		b.Description,
+		time.Now(),
+		b.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = setGenres(ctx, tx, b.ID, genreIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete deletes one book from the database, by ID. Its genre links are removed by the
+// books_genres foreign key's on delete cascade.
+//
+// Parameters:
+//
+// - none
+//
+// Returns:
+//
+// - error: an error
+func (b *Book) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `delete from books where id = $1`, b.ID)
+
+	return err
+}
+
+// SetCover records the storage key of a book's cover image; pass "" to remove it.
+//
+// Parameters:
+//
+// - coverPath: string: the storage key of the cover image
+//
+// Returns:
+//
+// - error: an error
+func (b *Book) SetCover(coverPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update books set cover_path = nullif($1, ''), updated_at = $2 where id = $3`
+
+	_, err := db.ExecContext(ctx, stmt, coverPath, time.Now(), b.ID)
+
+	return err
+}
+
+// ThumbnailPath returns the storage key of the thumbnail that goes with a cover image.
+//
+// Parameters:
+//
+// - coverPath: string: the storage key of the cover image
+//
+// Returns:
+//
+// - string: the storage key of the thumbnail
+func ThumbnailPath(coverPath string) string {
+	ext := path.Ext(coverPath)
+	return strings.TrimSuffix(coverPath, ext) + "_thumb.jpg"
+}
+
+// GetAll returns a slice of all authors, sorted by name.
+//
+// Parameters:
+// - none
+//
+// Returns:
+// - []*Author: a slice of type Author
+// - error: an error
+func (a *Author) GetAll() ([]*Author, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, coalesce(author_name, ''), coalesce(created_at, 'epoch'), coalesce(updated_at, 'epoch')
+		from authors order by author_name, id`
+
+	rows, err := readDB(a.primary).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var authors []*Author
+
+	for rows.Next() {
+		var author Author
+		err := rows.Scan(
+			&author.ID,
+			&author.AuthorName,
+			&author.CreatedAt,
+			&author.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		authors = append(authors, &author)
+	}
+
+	return authors, rows.Err()
+}
+
+// GetOne returns one author by id.
+//
+// Parameters:
+//
+// - id: int: the id of the author
+//
+// Returns:
+//
+// - *Author: a pointer to the Author model
+// - error: an error
+func (a *Author) GetOne(id int) (*Author, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, coalesce(author_name, ''), coalesce(created_at, 'epoch'), coalesce(updated_at, 'epoch')
+		from authors where id = $1`
+
+	var author Author
+	err := readDB(a.primary).QueryRowContext(ctx, query, id).Scan(
+		&author.ID,
+		&author.AuthorName,
+		&author.CreatedAt,
+		&author.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &author, nil
+}
+
+// Insert inserts a new author into the database, and returns the ID of the newly inserted row.
+//
+// Parameters:
+//
+// - author: Author: the author to insert
+//
+// Returns:
+//
+// - int: the id of the newly inserted row
+// - error: an error
+func (a *Author) Insert(author Author) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into authors (author_name, created_at, updated_at) values ($1, $2, $3) returning id`
+
+	err := db.QueryRowContext(ctx, stmt, author.AuthorName, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// Update updates one author in the database, using the information stored in the receiver a.
+//
+// Parameters:
+//
+// - none
+//
+// Returns:
+//
+// - error: an error
+func (a *Author) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update authors set author_name = $1, updated_at = $2 where id = $3`
+
+	_, err := db.ExecContext(ctx, stmt, a.AuthorName, time.Now(), a.ID)
+
+	return err
+}
+
+// Delete deletes one author from the database, by ID. The author's books are deleted
+// with it, by the books foreign key's on delete cascade.
+//
+// Parameters:
+//
+// - none
+//
+// Returns:
+//
+// - error: an error
+func (a *Author) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `delete from authors where id = $1`, a.ID)
+
+	return err
+}
+
+// GetAll returns a slice of all genres, sorted by name.
+//
+// Parameters:
+// - none
+//
+// Returns:
+// - []*Genre: a slice of type Genre
+// - error: an error
+func (g *Genre) GetAll() ([]*Genre, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, coalesce(genre_name, ''), coalesce(created_at, 'epoch'), coalesce(updated_at, 'epoch')
+		from genres order by genre_name, id`
+
+	rows, err := readDB(g.primary).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var genres []*Genre
+
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(
+			&genre.ID,
+			&genre.GenreName,
+			&genre.CreatedAt,
+			&genre.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	return genres, rows.Err()
+}
+
+// GetOne returns one genre by id.
+//
+// Parameters:
+//
+// - id: int: the id of the genre
+//
+// Returns:
+//
+// - *Genre: a pointer to the Genre model
+// - error: an error
+func (g *Genre) GetOne(id int) (*Genre, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, coalesce(genre_name, ''), coalesce(created_at, 'epoch'), coalesce(updated_at, 'epoch')
+		from genres where id = $1`
+
+	var genre Genre
+	err := readDB(g.primary).QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.GenreName,
+		&genre.CreatedAt,
+		&genre.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genre, nil
+}
+
+// Insert inserts a new genre into the database, and returns the ID of the newly inserted row.
+//
+// Parameters:
+//
+// - genre: Genre: the genre to insert
+//
+// Returns:
+//
+// - int: the id of the newly inserted row
+// - error: an error
+func (g *Genre) Insert(genre Genre) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into genres (genre_name, created_at, updated_at) values ($1, $2, $3) returning id`
+
+	err := db.QueryRowContext(ctx, stmt, genre.GenreName, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// Update updates one genre in the database, using the information stored in the receiver g.
+//
+// Parameters:
+//
+// - none
+//
+// Returns:
+//
+// - error: an error
+func (g *Genre) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update genres set genre_name = $1, updated_at = $2 where id = $3`
+
+	_, err := db.ExecContext(ctx, stmt, g.GenreName, time.Now(), g.ID)
+
+	return err
+}
+
+// Delete deletes one genre from the database, by ID. Links between books and the genre
+// are removed by the books_genres foreign key's on delete cascade.
+//
+// Parameters:
+//
+// - none
+//
+// Returns:
+//
+// - error: an error
+func (g *Genre) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `delete from genres where id = $1`, g.ID)
+
+	return err
+}
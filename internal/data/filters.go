@@ -0,0 +1,179 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a keyset pagination cursor cannot be decoded, or does
+// not hold a value of the sort column's type.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filters holds the pagination, sorting and search options for a list query.
+type Filters struct {
+	// Page is the 1-based page to return when paginating by offset.
+	Page int
+	// PageSize is the maximum number of records to return.
+	PageSize int
+	// Sort is the column to sort by; a leading "-" sorts in descending order.
+	Sort string
+	// SortSafelist is the list of values Sort may take.
+	SortSafelist []string
+	// Query is an optional free text search term.
+	Query string
+	// Cursor, if set, switches to keyset pagination: records after the one the cursor
+	// points at are returned, and Page is ignored. Cursors come from Metadata.NextCursor.
+	Cursor string
+}
+
+// Metadata describes where a page of results sits within the full result set.
+type Metadata struct {
+	// CurrentPage is the page that was returned, or zero when paginating by cursor.
+	CurrentPage int `json:"current_page,omitempty"`
+	// PageSize is the maximum number of records on a page.
+	PageSize int `json:"page_size,omitempty"`
+	// FirstPage is always 1, unless there are no records at all.
+	FirstPage int `json:"first_page,omitempty"`
+	// LastPage is the number of the last page.
+	LastPage int `json:"last_page,omitempty"`
+	// TotalRecords is the number of records matching the search, across every page. It
+	// is not counted, and so is nil, when paginating by cursor, since counting would
+	// cost as much as an offset into the whole result set.
+	TotalRecords *int `json:"total_records,omitempty"`
+	// NextCursor can be passed back as Filters.Cursor to fetch the following page. It is
+	// empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// cursor is the decoded form of a keyset pagination cursor: the value of the sort column
+// and the id of the last record on the previous page.
+type cursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// sortColumn returns the column to sort by, checked against the safelist. It panics if
+// the value is not in the safelist, since Filters are validated before they reach here
+// and an unchecked value would be interpolated into SQL.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// sortDirection returns "desc" if Sort starts with "-", and "asc" otherwise.
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "desc"
+	}
+
+	return "asc"
+}
+
+// limit returns the number of records to fetch for one page.
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+// offset returns the number of records to skip to reach the current page.
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// searchPattern returns Query as an ILIKE pattern matching anywhere in a column, with any
+// wildcard characters in the query escaped.
+func (f Filters) searchPattern() string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(f.Query) + "%"
+}
+
+// keysetClause returns the condition that selects records after the cursor, for a sort
+// column of the given Postgres type. The cursor value and id are bound to the placeholders
+// numbered valueArg and valueArg+1.
+func (f Filters) keysetClause(sqlType string, valueArg int) string {
+	op := ">"
+	if f.sortDirection() == "desc" {
+		op = "<"
+	}
+
+	return fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", f.sortColumn(), op, valueArg, sqlType, valueArg+1)
+}
+
+// cursorTimeFormat is the format timestamps are written in within cursors.
+const cursorTimeFormat = "2006-01-02 15:04:05.999999"
+
+// encodeCursor builds an opaque cursor pointing at a record.
+func encodeCursor(value string, id int) string {
+	js, _ := json.Marshal(cursor{Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor reverses encodeCursor, and checks that the cursor's value can be cast to
+// the Postgres type of the sort column, so that a tampered cursor is rejected here rather
+// than failing in the database.
+func decodeCursor(s, sqlType string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return c, ErrInvalidCursor
+	}
+
+	switch sqlType {
+	case "integer":
+		_, err = strconv.ParseInt(c.Value, 10, 32)
+	case "timestamp":
+		_, err = time.Parse(cursorTimeFormat, c.Value)
+	case "text":
+		if strings.ContainsRune(c.Value, 0) {
+			err = ErrInvalidCursor
+		}
+	}
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// calculateMetadata works out the pagination metadata for a page of results.
+//
+// Parameters:
+// - totalRecords: int: the number of records matching the search; ignored when
+// paginating by cursor
+// - filters: Filters: the filters used to fetch the page
+//
+// Returns:
+// - Metadata: the pagination metadata
+func calculateMetadata(totalRecords int, filters Filters) Metadata {
+	if filters.Cursor != "" {
+		return Metadata{PageSize: filters.PageSize}
+	}
+
+	if totalRecords == 0 {
+		return Metadata{PageSize: filters.PageSize, TotalRecords: &totalRecords}
+	}
+
+	return Metadata{
+		CurrentPage:  filters.Page,
+		PageSize:     filters.PageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(filters.PageSize))),
+		TotalRecords: &totalRecords,
+	}
+}
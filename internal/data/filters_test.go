@@ -0,0 +1,43 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestDecodeCursor checks that cursors round trip, and that a cursor whose value does not
+// fit the sort column's type is rejected before it reaches the database.
+func TestDecodeCursor(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC).Format(cursorTimeFormat)
+
+	tests := []struct {
+		name    string
+		cursor  string
+		sqlType string
+		valid   bool
+	}{
+		{"integer", encodeCursor("42", 42), "integer", true},
+		{"text", encodeCursor("Le Guin", 7), "text", true},
+		{"timestamp", encodeCursor(created, 7), "timestamp", true},
+		{"text for integer", encodeCursor("abc", 7), "integer", false},
+		{"text for timestamp", encodeCursor("yesterday", 7), "timestamp", false},
+		{"nul in text", encodeCursor("a\x00b", 7), "text", false},
+		{"missing id", encodeCursor("42", 0), "integer", false},
+		{"not base64", "!!!", "integer", false},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("nope")), "integer", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.sqlType)
+			if tt.valid && err != nil {
+				t.Errorf("decodeCursor: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor error = %v; want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Reasons a sign in failed, recorded in LoginAttempt.Reason. Only failures with
+// LoginFailedCredentials count towards throttling and lockout; the others record
+// attempts that were refused before or after the password was checked.
+const (
+	LoginFailedCredentials = "invalid_credentials"
+	LoginFailedThrottled   = "throttled"
+	LoginFailedLocked      = "locked"
+	LoginFailedUnverified  = "unverified"
+)
+
+// dummyPasswordHash is a bcrypt hash, at the cost Insert uses, that no password is
+// expected to match; see DummyPasswordCheck.
+var dummyPasswordHash = []byte("$2a$12$TM2N3kIJKcMMRGfgDk.jYey8iGEXsN3mkyw5JjD5lhlCbVFv4aKye")
+
+// LoginAttempt is a failed attempt to sign in. Every one is kept, as an audit trail.
+type LoginAttempt struct {
+	// ID is the primary key for the attempt.
+	ID int64 `json:"id"`
+	// Email is the email address the attempt was made with.
+	Email string `json:"email"`
+	// UserID is the id of the user with that email address, or nil if there is none.
+	UserID *int `json:"user_id"`
+	// IPAddress is the address of the client that made the attempt.
+	IPAddress string `json:"ip_address"`
+	// UserAgent is the User-Agent header of the attempt.
+	UserAgent string `json:"user_agent"`
+	// Reason is why the attempt failed; one of the LoginFailed constants.
+	Reason string `json:"reason"`
+	// Cleared is true once the failure no longer counts against the account, because
+	// the user has since signed in or an administrator has unlocked the account.
+	Cleared bool `json:"cleared"`
+	// CreatedAt is the time the attempt was made.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginFailures counts the recent failed sign ins, by reason of bad credentials, for one
+// email address and for one client address.
+type LoginFailures struct {
+	// Account is the number of failures for the email address that have not been cleared.
+	Account int
+	// AccountLast is the time of the latest of them; it is zero if there are none.
+	AccountLast time.Time
+	// Address is the number of failures from the client address, whatever the account.
+	Address int
+	// AddressLast is the time of the latest of them; it is zero if there are none.
+	AddressLast time.Time
+}
+
+// DummyPasswordCheck compares plainText with a hash that matches nothing. Calling it
+// when there is no user to check a password against makes a sign in with an unknown email
+// address take as long as one with a wrong password, so the two cannot be told apart.
+//
+// Parameters:
+//
+// - plainText: string: the password that was supplied
+func DummyPasswordCheck(plainText string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plainText))
+}
+
+// Record adds a sign in attempt to the audit trail before its password is checked, as a
+// failure with LoginFailedCredentials, and returns the recent failures that came before
+// it. Counting and recording happen under a lock on the email address and one on the
+// client address, so that of several attempts made at once each sees all those before
+// it, and a burst of guesses cannot all slip in under the limit. Once the outcome is
+// known, the caller corrects the record with SetReason, or removes it with Delete if
+// the attempt succeeded. The email address, client address and user agent are cut to
+// the lengths the table holds.
+//
+// Parameters:
+//
+// - attempt: *LoginAttempt: the attempt to record; its ID and Reason are set, and
+// Cleared and CreatedAt are ignored
+// - since: time.Time: the earliest failure to count
+//
+// Returns:
+//
+// - *LoginFailures: the counts, not including this attempt
+// - error: an error
+func (a *LoginAttempt) Record(attempt *LoginAttempt, since time.Time) (*LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// always the email address first, then the client address, so that two attempts
+	// cannot wait on each other
+	_, err = tx.ExecContext(ctx, `select pg_advisory_xact_lock(hashtext('login_attempts:' || lower($1)))`, attempt.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `select pg_advisory_xact_lock(hashtext('login_attempts:' || $1))`, attempt.IPAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := recentFailures(ctx, tx, attempt.Email, attempt.IPAddress, since)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `insert into login_attempts (email, user_id, ip_address, user_agent, reason)
+		values (left($1, 255), $2, left($3, 45), left($4, 512), $5) returning id`
+
+	attempt.Reason = LoginFailedCredentials
+	err = tx.QueryRowContext(ctx, stmt,
+		attempt.Email,
+		attempt.UserID,
+		attempt.IPAddress,
+		attempt.UserAgent,
+		attempt.Reason,
+	).Scan(&attempt.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+// recentFailures counts the failed sign ins since a given time, for an email address,
+// ignoring case, and for a client address. Only failures with LoginFailedCredentials are
+// counted, and for the email address only those that have not been cleared.
+//
+// Parameters:
+//
+// - ctx: context.Context: the context of the query
+// - tx: *sql.Tx: the transaction to count in
+// - email: string: the email address the sign in is for
+// - ipAddress: string: the address of the client
+// - since: time.Time: the earliest failure to count
+//
+// Returns:
+//
+// - *LoginFailures: the counts
+// - error: an error
+func recentFailures(ctx context.Context, tx *sql.Tx, email, ipAddress string, since time.Time) (*LoginFailures, error) {
+	query := `select
+			count(*) filter (where lower(email) = lower($1) and not cleared),
+			max(created_at) filter (where lower(email) = lower($1) and not cleared),
+			count(*) filter (where ip_address = $2),
+			max(created_at) filter (where ip_address = $2)
+		from login_attempts
+		where (lower(email) = lower($1) or ip_address = $2)
+			and reason = $3 and created_at >= $4`
+
+	var failures LoginFailures
+	var accountLast, addressLast *time.Time
+
+	err := tx.QueryRowContext(ctx, query, email, ipAddress, LoginFailedCredentials, since).Scan(
+		&failures.Account,
+		&accountLast,
+		&failures.Address,
+		&addressLast,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if accountLast != nil {
+		failures.AccountLast = *accountLast
+	}
+	if addressLast != nil {
+		failures.AddressLast = *addressLast
+	}
+
+	return &failures, nil
+}
+
+// SetReason changes why a recorded sign in attempt failed, once that is known.
+//
+// Parameters:
+//
+// - id: int64: the id of the attempt
+// - reason: string: why it failed; one of the LoginFailed constants
+//
+// Returns:
+//
+// - error: an error
+func (a *LoginAttempt) SetReason(id int64, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update login_attempts set reason = $1 where id = $2`, reason, id)
+
+	return err
+}
+
+// Delete removes a recorded sign in attempt, which is done when it turns out to have
+// succeeded; only failures belong in the audit trail.
+//
+// Parameters:
+//
+// - id: int64: the id of the attempt
+//
+// Returns:
+//
+// - error: an error
+func (a *LoginAttempt) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `delete from login_attempts where id = $1`, id)
+
+	return err
+}
+
+// Clear stops the failed sign ins for an email address, ignoring case, from counting
+// against it, which unlocks the account. The attempts stay on record.
+//
+// Parameters:
+//
+// - email: string: the email address of the account
+//
+// Returns:
+//
+// - int64: the number of failures cleared
+// - error: an error
+func (a *LoginAttempt) Clear(email string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update login_attempts set cleared = true where lower(email) = lower($1) and not cleared`
+
+	result, err := db.ExecContext(ctx, stmt, email)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetAllForUser returns the most recent failed sign ins to a user's account, newest
+// first.
+//
+// Parameters:
+//
+// - userID: int: the id of the user
+// - limit: int: the most attempts to return
+//
+// Returns:
+//
+// - []*LoginAttempt: a slice of type LoginAttempt
+// - error: an error
+func (a *LoginAttempt) GetAllForUser(userID, limit int) ([]*LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, email, user_id, ip_address, user_agent, reason, cleared, created_at
+		from login_attempts where user_id = $1 order by created_at desc, id desc limit $2`
+
+	rows, err := db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*LoginAttempt
+
+	for rows.Next() {
+		var attempt LoginAttempt
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.Email,
+			&attempt.UserID,
+			&attempt.IPAddress,
+			&attempt.UserAgent,
+			&attempt.Reason,
+			&attempt.Cleared,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, &attempt)
+	}
+
+	return attempts, rows.Err()
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/base32"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,16 +19,52 @@ import (
 
 const dbTimeout = time.Second * 3
 
+// Token scopes. Authentication tokens are sent as bearer tokens on each request;
+// refresh tokens may only be exchanged for a new pair of tokens.
+const (
+	ScopeAuthentication = "authentication"
+	ScopeRefresh        = "refresh"
+)
+
+// ErrTokenReused is returned when a refresh token that has already been rotated is
+// presented again. Every token in its family is revoked when this happens, since it
+// means the token has most likely been stolen.
+var ErrTokenReused = errors.New("refresh token has already been used")
+
 var db *sql.DB
 
+// replica, if set, returns the pool that reads which tolerate replication lag should use.
+var replica func() *sql.DB
+
 // New is the function used to create an instance of the data package. It returns the type
 // Model, which embeds all of the types we want to be available to our application.
 func New(dbPool *sql.DB) Models {
-	db = dbPool
+	return NewWithReplicas(dbPool, nil)
+}
+
+// NewWithReplicas is like New, but sends reads of users and of the book catalog to the
+// pool returned by reader, which is typically a read replica. Writes, and every read of
+// tokens, always use the primary. Use Models.Primary where a read must see a write that
+// was just made.
+//
+// Parameters:
+//   - primary: The pool for the primary database.
+//   - reader: Returns the pool to read from; nil sends every read to the primary.
+//
+// Returns:
+//   - The models.
+func NewWithReplicas(primary *sql.DB, reader func() *sql.DB) Models {
+	db = primary
+	replica = reader
 
 	return Models{
-		User:  User{},
-		Token: Token{},
+		User:         User{},
+		Token:        Token{},
+		Book:         Book{},
+		Author:       Author{},
+		Genre:        Genre{},
+		Search:       Search{},
+		LoginAttempt: LoginAttempt{},
 	}
 }
 
@@ -35,6 +74,42 @@ type Models struct {
 	User User
 	// Token is the data model for a Token.
 	Token Token
+	// Book is the data model for a Book.
+	Book Book
+	// Author is the data model for an Author.
+	Author Author
+	// Genre is the data model for a Genre.
+	Genre Genre
+	// Search is the data model for searching the book catalog.
+	Search Search
+	// LoginAttempt is the data model for failed sign ins. Like Token, it always reads
+	// from the primary.
+	LoginAttempt LoginAttempt
+}
+
+// Primary returns a copy of the models whose reads all go to the primary database, for
+// reading back data straight after writing it, before it may have reached the replicas.
+//
+// Returns:
+//   - The models.
+func (m Models) Primary() *Models {
+	m.User.primary = true
+	m.Book.primary = true
+	m.Author.primary = true
+	m.Genre.primary = true
+	m.Search.primary = true
+
+	return &m
+}
+
+// readDB returns the pool a read should use: a replica, unless primary is set or no
+// replicas are configured.
+func readDB(primary bool) *sql.DB {
+	if primary || replica == nil {
+		return db
+	}
+
+	return replica()
 }
 
 // User represents a user in the database.
@@ -47,34 +122,96 @@ type User struct {
 	FirstName string `json:"first_name,omitempty"`
 	// LastName is the last name for the user.
 	LastName string `json:"last_name,omitempty"`
-	// Password is the password for the user.
-	Password string `json:"password"`
+	// Password is the bcrypt hash of the user's password, or the plain text password
+	// when passed to Insert. It is never serialized.
+	Password string `json:"-"`
+	// IsAdmin is true if the user may manage other users.
+	IsAdmin bool `json:"is_admin"`
+	// VerifiedAt is the time the user proved they own their email address, or nil if they
+	// have not yet.
+	VerifiedAt *time.Time `json:"verified_at"`
 	// CreatedAt is the time the user was created.
 	CreatedAt time.Time `json:"created_at"`
 	// UpdatedAt is the time the user was last updated.
 	UpdatedAt time.Time `json:"updated_at"`
 	// Token is the token for the user.
 	Token Token `json:"token"`
+
+	// primary sends this model's reads to the primary database; see Models.Primary.
+	primary bool
+}
+
+// UserSortSafelist is the list of values accepted as Filters.Sort when listing users.
+var UserSortSafelist = []string{
+	"id", "email", "first_name", "last_name", "created_at",
+	"-id", "-email", "-first_name", "-last_name", "-created_at",
+}
+
+// userSortTypes maps each sortable users column to its Postgres type, which is needed to
+// compare a keyset cursor value against it.
+var userSortTypes = map[string]string{
+	"id":         "integer",
+	"email":      "text",
+	"first_name": "text",
+	"last_name":  "text",
+	"created_at": "timestamp",
 }
 
-// GetAll returns a slice of all users, sorted by last name
-// It returns in a slice of type User and an error.
+// GetAll returns one page of users matching the filters, along with the pagination
+// metadata for the whole result set. Filters.Query matches against first name, last
+// name and email; ties in the sort column are broken by id, so paging is stable.
 //
 // Parameters:
-// - none
+// - filters: Filters: the pagination, sorting and search options
 //
 // Returns:
 // - []*User: a slice of type User
+// - Metadata: the pagination metadata
 // - error: an error
-func (u *User) GetAll() ([]*User, error) {
+func (u *User) GetAll(filters Filters) ([]*User, Metadata, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, created_at, updated_at from users order by last_name`
+	where := `($1 = '' or first_name ilike $2 or last_name ilike $2 or email ilike $2)`
+	args := []any{filters.Query, filters.searchPattern()}
+
+	// the total is only counted for offset pagination; a cursor keeps deep pages fast,
+	// and counting every match would undo that
+	var totalRecords int
+	if filters.Cursor == "" {
+		err := readDB(u.primary).QueryRowContext(ctx, `select count(*) from users where `+where, args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	if filters.Cursor != "" {
+		c, err := decodeCursor(filters.Cursor, userSortTypes[column])
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		where += ` and ` + filters.keysetClause(userSortTypes[column], len(args)+1)
+		args = append(args, c.Value, c.ID)
+	}
+
+	query := fmt.Sprintf(`select id, email, first_name, last_name, password, is_admin, verified_at, created_at, updated_at
+		from users where %s order by %s %s, id %s`, where, column, direction, direction)
 
-	rows, err := db.QueryContext(ctx, query)
+	// fetch one extra row, to find out whether there is a next page
+	args = append(args, filters.limit()+1)
+	query += fmt.Sprintf(` limit $%d`, len(args))
+
+	if filters.Cursor == "" {
+		args = append(args, filters.offset())
+		query += fmt.Sprintf(` offset $%d`, len(args))
+	}
+
+	rows, err := readDB(u.primary).QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
@@ -88,20 +225,52 @@ func (u *User) GetAll() ([]*User, error) {
 			&user.FirstName,
 			&user.LastName,
 			&user.Password,
+			&user.IsAdmin,
+			&user.VerifiedAt,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		users = append(users, &user)
 	}
 
-	return users, nil
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters)
+
+	if len(users) > filters.limit() {
+		users = users[:filters.limit()]
+		last := users[len(users)-1]
+		metadata.NextCursor = encodeCursor(last.sortValue(column), last.ID)
+	}
+
+	return users, metadata, nil
+}
+
+// sortValue returns the value of one of the columns in UserSortSafelist, formatted so
+// that Postgres can cast it back to the column's type.
+func (u *User) sortValue(column string) string {
+	switch column {
+	case "email":
+		return u.Email
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "created_at":
+		return u.CreatedAt.Format(cursorTimeFormat)
+	default:
+		return strconv.Itoa(u.ID)
+	}
 }
 
 // GetByEmail takes in a email of type string and returns a pointer to the User model and an error.
+// The email address is compared ignoring case.
 //
 // Parameters:
 //
@@ -115,10 +284,10 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, is_admin, verified_at, created_at, updated_at from users where lower(email) = lower($1)`
 
 	var user User
-	row := db.QueryRowContext(ctx, query, email)
+	row := readDB(u.primary).QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -126,6 +295,8 @@ func (u *User) GetByEmail(email string) (*User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.IsAdmin,
+		&user.VerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -151,10 +322,10 @@ func (u *User) GetOne(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, is_admin, verified_at, created_at, updated_at from users where id = $1`
 
 	var user User
-	row := db.QueryRowContext(ctx, query, id)
+	row := readDB(u.primary).QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
@@ -162,6 +333,8 @@ func (u *User) GetOne(id int) (*User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.IsAdmin,
+		&user.VerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -190,14 +363,18 @@ func (u *User) Update() error {
 		email = $1,
 		first_name = $2,
 		last_name = $3,
-		updated_at = $4
-		where id = $5
+		is_admin = $4,
+		verified_at = $5,
+		updated_at = $6
+		where id = $7
 	`
 
 	_, err := db.ExecContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
+		u.IsAdmin,
+		u.VerifiedAt,
 		time.Now(),
 		u.ID,
 	)
@@ -252,14 +429,16 @@ func (u *User) Insert(user User) (int, error) {
 	}
 
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, is_admin, verified_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
 
 	err = db.QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
 		hashedPassword,
+		user.IsAdmin,
+		user.VerifiedAt,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -289,8 +468,8 @@ func (u *User) ResetPassword(password string) error {
 		return err
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = db.ExecContext(ctx, stmt, hashedPassword, u.ID)
+	stmt := `update users set password = $1, updated_at = $2 where id = $3`
+	_, err = db.ExecContext(ctx, stmt, hashedPassword, time.Now(), u.ID)
 	if err != nil {
 		return err
 	}
@@ -332,20 +511,38 @@ type Token struct {
 	UserID int `json:"user_id"`
 	// Email is the email address for the user.
 	Email string `json:"email"`
-	// Token is the token for the user.
-	Token string `json:"token"`
+	// Token is the token for the user. It is only known at the moment the token
+	// is generated, since the database stores nothing but its hash.
+	Token string `json:"token,omitempty"`
 	// TokenHash is the hash of the token.
 	TokenHash []byte `json:"-"`
+	// UserAgent is the user agent of the client the token was issued to.
+	UserAgent string `json:"user_agent"`
+	// IPAddress is the address of the client the token was issued to.
+	IPAddress string `json:"ip_address"`
+	// DeviceLabel is a human readable name for the device, such as "Work laptop".
+	DeviceLabel string `json:"device_label"`
+	// Scope is either ScopeAuthentication or ScopeRefresh.
+	Scope string `json:"scope"`
+	// FamilyID groups the authentication and refresh tokens that descend from a
+	// single login. Revoking a session revokes its whole family.
+	FamilyID string `json:"-"`
+	// RotatedAt is the time a refresh token was exchanged for a new pair, or nil if
+	// it has not been used yet.
+	RotatedAt *time.Time `json:"-"`
 	// CreatedAt is the time the token was created.
 	CreatedAt time.Time `json:"created_at"`
 	// UpdatedAt is the time the token was last updated.
 	UpdatedAt time.Time `json:"updated_at"`
+	// LastUsedAt is the time the token was last used to authenticate a request.
+	LastUsedAt time.Time `json:"last_used_at"`
 	// Expiry is the time the token expires.
 	Expiry time.Time `json:"expiry"`
 }
 
 // GetByToken takes a plain text token string, and looks up the full token from
-// the database. It returns a pointer to the Token model.
+// the database by its SHA-256 hash; the plain text token is never stored. It
+// returns a pointer to the Token model.
 //
 // Parameter:
 // - plainText: string: the plain text token to look up
@@ -357,23 +554,44 @@ func (t *Token) GetByToken(plainText string) (*Token, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, user_id, email, token, token_hash, created_at, updated_at, expiry
-			from tokens where token = $1`
+	query := `select ` + tokenColumns + ` from tokens where token_hash = $1`
+
+	row := db.QueryRowContext(ctx, query, hashToken(plainText))
+	token, err := scanToken(row)
+	if err != nil {
+		return nil, err
+	}
 
+	token.Token = plainText
+
+	return token, nil
+}
+
+// tokenColumns is the list of columns selected whenever a full token is read; it
+// matches the order in which scanToken reads them.
+const tokenColumns = `id, user_id, email, token_hash, user_agent, ip_address, device_label,
+	scope, family_id, rotated_at, created_at, updated_at, last_used_at, expiry`
+
+// scanToken reads a single token, selected using tokenColumns, from a row.
+func scanToken(row interface{ Scan(...any) error }) (*Token, error) {
 	var token Token
 
-	row := db.QueryRowContext(ctx, query, plainText)
 	err := row.Scan(
 		&token.ID,
 		&token.UserID,
 		&token.Email,
-		&token.Token,
 		&token.TokenHash,
+		&token.UserAgent,
+		&token.IPAddress,
+		&token.DeviceLabel,
+		&token.Scope,
+		&token.FamilyID,
+		&token.RotatedAt,
 		&token.CreatedAt,
 		&token.UpdatedAt,
+		&token.LastUsedAt,
 		&token.Expiry,
 	)
-
 	if err != nil {
 		return nil, err
 	}
@@ -394,7 +612,7 @@ func (t *Token) GetUserForToken(token Token) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, is_admin, verified_at, created_at, updated_at from users where id = $1`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, token.UserID)
@@ -405,6 +623,8 @@ func (t *Token) GetUserForToken(token Token) (*User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.IsAdmin,
+		&user.VerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -428,56 +648,109 @@ func (t *Token) GetUserForToken(token Token) (*User, error) {
 func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 	token := &Token{
 		UserID: userID,
+		Scope:  ScopeAuthentication,
 		Expiry: time.Now().Add(ttl),
 	}
 
-	randomBytes := make([]byte, 16)
-	_, err := rand.Read(randomBytes)
+	plainText, err := randomString()
 	if err != nil {
 		return nil, err
 	}
 
-	token.Token = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
-	hash := sha256.Sum256([]byte(token.Token))
-	token.TokenHash = hash[:]
+	token.Token = plainText
+	token.TokenHash = hashToken(token.Token)
+
+	// a token starts its own family unless the caller places it in an existing one
+	token.FamilyID, err = randomString()
+	if err != nil {
+		return nil, err
+	}
 
 	return token, nil
 }
 
-// AuthenticateToken takes the full http request, extracts the authorization header, takes the plain text token from that header and looks up the associated token entry in the database, and then finds the user associated with that token. If the token is valid and a user is found, the user is returned; otherwise, it returns an error.
+// randomString returns 26 characters of base32 encoded, cryptographically secure
+// random data.
+func randomString() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// hashToken returns the SHA-256 hash of a plain text token. This is the only form
+// in which a token is stored, so every lookup must go through it.
+func hashToken(plainText string) []byte {
+	hash := sha256.Sum256([]byte(plainText))
+	return hash[:]
+}
+
+// BearerToken extracts the plain text token from the authorization header of a request.
+// The header must be of the form "Bearer <token>", and the token must be exactly 26
+// characters long, which is the length of every token produced by GenerateToken.
 //
 // Parameter:
 // - r: *http.Request: the http request
 //
 // Returns:
-// - *User: a pointer to the User model
+// - string: the plain text token
 // - error: an error
-func (t *Token) AuthenticateToken(r *http.Request) (*User, error) {
+func BearerToken(r *http.Request) (string, error) {
 	// get the authorization header
 	authorizationHeader := r.Header.Get("Authorization")
 	if authorizationHeader == "" {
-		return nil, errors.New("no authorization header received")
+		return "", errors.New("no authorization header received")
 	}
 
 	// get the plain text token from the header
 	headerParts := strings.Split(authorizationHeader, " ")
 	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return nil, errors.New("no valid authorization header received")
+		return "", errors.New("no valid authorization header received")
 	}
 
 	token := headerParts[1]
 
 	// make sure the token is of the correct length
 	if len(token) != 26 {
-		return nil, errors.New("token wrong size")
+		return "", errors.New("token wrong size")
 	}
 
-	// get the token from the database, using the plain text token to find it
+	return token, nil
+}
+
+// AuthenticateToken takes the full http request, extracts the authorization header, takes the plain text token from that header and looks up the associated token entry in the database, and then finds the user associated with that token. If the token is valid and a user is found, the user is returned; otherwise, it returns an error.
+//
+// With an idle timeout, using the token also pushes its expiry forward; see Touch.
+//
+// Parameter:
+// - r: *http.Request: the http request
+// - idle: time.Duration: how long the token may go unused before it expires; 0 leaves the expiry alone
+// - lifetime: time.Duration: the longest the token may live, however often it is used
+//
+// Returns:
+// - *User: a pointer to the User model
+// - error: an error
+func (t *Token) AuthenticateToken(r *http.Request, idle, lifetime time.Duration) (*User, error) {
+	// get the plain text token from the authorization header
+	token, err := BearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// get the token from the database, using the hash of the plain text token to find it
 	tkn, err := t.GetByToken(token)
 	if err != nil {
 		return nil, errors.New("no matching token found")
 	}
 
+	// refresh tokens may only be exchanged for new tokens, never used to authenticate
+	if tkn.Scope != ScopeAuthentication {
+		return nil, errors.New("token has wrong scope")
+	}
+
 	// make sure the token has not expired
 	if tkn.Expiry.Before(time.Now()) {
 		return nil, errors.New("expired token")
@@ -489,41 +762,148 @@ func (t *Token) AuthenticateToken(r *http.Request) (*User, error) {
 		return nil, errors.New("no matching user found")
 	}
 
+	// record when the session was last used, and keep it alive a while longer
+	err = t.Touch(*tkn, idle, lifetime)
+	if err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
-// Insert inserts a token into the database.
+// Touch records that a token has just been used to authenticate a request. With an idle
+// timeout, it also pushes the token's expiry forward, so that the token only expires once
+// it has gone unused for that long. The expiry never moves backwards, and never passes
+// the token's creation time plus its absolute lifetime. Both happen in one update.
 //
 // Parameter:
-// - token: Token: the token to insert
-// - u: User: the user to associate with the token
+// - token: Token: the token that was used
+// - idle: time.Duration: how long the token may go unused before it expires; 0 leaves the expiry alone
+// - lifetime: time.Duration: the longest the token may live, however often it is used
 //
 // Returns:
 // - error: an error
-func (t *Token) Insert(token Token, u User) error {
+func (t *Token) Touch(token Token, idle, lifetime time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// delete any existing tokens
-	stmt := `delete from tokens where user_id = $1`
-	_, err := db.ExecContext(ctx, stmt, token.UserID)
-	if err != nil {
+	now := time.Now()
+
+	if idle <= 0 {
+		_, err := db.ExecContext(ctx, `update tokens set last_used_at = $1 where id = $2`, now, token.ID)
 		return err
 	}
 
+	stmt := `update tokens set last_used_at = $1, updated_at = $1,
+			expiry = least(greatest(expiry, $2), created_at + make_interval(secs => $3))
+		where id = $4`
+
+	_, err := db.ExecContext(ctx, stmt, now, now.Add(idle), lifetime.Seconds(), token.ID)
+
+	return err
+}
+
+// UseRefreshToken exchanges a refresh token for the right to issue a new token pair.
+// The refresh token is marked as rotated and the authentication tokens in its family
+// are revoked; the caller is expected to insert the new pair into the same family.
+// If the refresh token has already been rotated, the whole family is revoked and
+// ErrTokenReused is returned.
+//
+// Parameter:
+// - plainText: string: the plain text refresh token
+//
+// Returns:
+// - *Token: the refresh token that was used
+// - error: an error
+func (t *Token) UseRefreshToken(plainText string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `select ` + tokenColumns + ` from tokens where token_hash = $1 and scope = $2 for update`
+
+	token, err := scanToken(tx.QueryRowContext(ctx, query, hashToken(plainText), ScopeRefresh))
+	if err != nil {
+		return nil, errors.New("no matching token found")
+	}
+
+	if token.RotatedAt != nil {
+		_, err = tx.ExecContext(ctx, `delete from tokens where family_id = $1`, token.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrTokenReused
+	}
+
+	if token.Expiry.Before(time.Now()) {
+		return nil, errors.New("expired token")
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `update tokens set rotated_at = $1, updated_at = $1 where id = $2`, now, token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from tokens where family_id = $1 and scope = $2`,
+		token.FamilyID, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	token.Token = plainText
+
+	return token, nil
+}
+
+// Insert inserts a token into the database. Existing tokens for the user are left
+// alone, so a user may hold several sessions at once; use PruneForUser to cap them.
+//
+// Parameter:
+// - token: Token: the token to insert
+// - u: User: the user to associate with the token
+//
+// Returns:
+// - error: an error
+func (t *Token) Insert(token Token, u User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
 	// we assign the email value, just to be safe, in case it was
 	// not done in the handler that calls this function
 	token.Email = u.Email
 
-	// insert the new token
-	stmt = `insert into tokens (user_id, email, token, token_hash, created_at, updated_at, expiry)
-		values ($1, $2, $3, $4, $5, $6, $7)`
+	// insert the new token; only the hash is stored, never the plain text
+	stmt := `insert into tokens (user_id, email, token_hash, user_agent, ip_address, device_label,
+		scope, family_id, created_at, updated_at, last_used_at, expiry)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
 
-	_, err = db.ExecContext(ctx, stmt,
+	_, err := db.ExecContext(ctx, stmt,
 		token.UserID,
 		token.Email,
-		token.Token,
 		token.TokenHash,
+		token.UserAgent,
+		token.IPAddress,
+		token.DeviceLabel,
+		token.Scope,
+		token.FamilyID,
+		time.Now(),
 		time.Now(),
 		time.Now(),
 		token.Expiry,
@@ -535,7 +915,8 @@ func (t *Token) Insert(token Token, u User) error {
 	return nil
 }
 
-// DeleteByToken deletes a token, by plain text token.
+// DeleteByToken deletes a token, by the hash of its plain text token, along with every
+// other token in its family.
 //
 // Parameter:
 // - plainText: string: the plain text token to delete
@@ -546,9 +927,83 @@ func (t *Token) DeleteByToken(plainText string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from tokens where token = $1`
+	stmt := `delete from tokens where family_id in (select family_id from tokens where token_hash = $1)`
+
+	_, err := db.ExecContext(ctx, stmt, hashToken(plainText))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAllForUser returns every authentication token belonging to a user, newest first.
+// Each one represents a signed in session.
+//
+// Parameter:
+// - userID: int: the id of the user whose tokens should be returned
+//
+// Returns:
+// - []*Token: a slice of type Token
+// - error: an error
+func (t *Token) GetAllForUser(userID int) ([]*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + tokenColumns + ` from tokens
+			where user_id = $1 and scope = $2 order by created_at desc`
+
+	rows, err := db.QueryContext(ctx, query, userID, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*Token
+
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// MatchesPlainText reports whether the receiver is the token identified by the given
+// plain text token. The hashes are compared in constant time.
+//
+// Parameter:
+// - plainText: string: the plain text token to compare against
+//
+// Returns:
+// - bool: true if the token matches, false otherwise
+func (t *Token) MatchesPlainText(plainText string) bool {
+	return subtle.ConstantTimeCompare(t.TokenHash, hashToken(plainText)) == 1
+}
+
+// DeleteForUser deletes a single session by the id of one of its tokens, provided that it
+// belongs to the given user. The whole token family is deleted, so the session's refresh
+// token stops working too. Deleting a token that does not exist is not an error.
+//
+// Parameter:
+// - id: int: the id of the token to delete
+// - userID: int: the id of the user the token must belong to
+//
+// Returns:
+// - error: an error
+func (t *Token) DeleteForUser(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from tokens where user_id = $2 and family_id in (
+		select family_id from tokens where id = $1 and user_id = $2
+	)`
 
-	_, err := db.ExecContext(ctx, stmt, plainText)
+	_, err := db.ExecContext(ctx, stmt, id, userID)
 	if err != nil {
 		return err
 	}
@@ -556,6 +1011,100 @@ func (t *Token) DeleteByToken(plainText string) error {
 	return nil
 }
 
+// PruneForUser deletes a user's oldest sessions so that no more than keep remain. A keep
+// value of zero or less disables the cap.
+//
+// Parameter:
+// - userID: int: the id of the user whose tokens should be pruned
+// - keep: int: the maximum number of tokens to keep
+//
+// Returns:
+// - error: an error
+func (t *Token) PruneForUser(userID, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from tokens where user_id = $1 and family_id in (
+		select family_id from tokens where user_id = $1 and scope = $2
+		order by created_at desc, id desc offset $3
+	)`
+
+	_, err := db.ExecContext(ctx, stmt, userID, ScopeAuthentication, keep)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteByUserID deletes every token belonging to a user, signing that user out
+// of every device. Deleting when the user has no tokens is not an error.
+//
+// Parameter:
+// - userID: int: the id of the user whose tokens should be deleted
+//
+// Returns:
+// - error: an error
+func (t *Token) DeleteByUserID(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from tokens where user_id = $1`
+
+	_, err := db.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteOthersForUser deletes every token belonging to a user except those in one token
+// family, signing the user out of every other device.
+//
+// Parameter:
+// - userID: int: the id of the user whose tokens should be deleted
+// - keepFamilyID: string: the family of the session to keep
+//
+// Returns:
+// - error: an error
+func (t *Token) DeleteOthersForUser(userID int, keepFamilyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from tokens where user_id = $1 and family_id <> $2`
+
+	_, err := db.ExecContext(ctx, stmt, userID, keepFamilyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes every token whose expiry has passed, and returns how many were
+// deleted. Rotated refresh tokens are kept until they expire, so that reuse of one can
+// still be detected.
+//
+// Returns:
+// - int64: the number of tokens deleted
+// - error: an error
+func (t *Token) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `delete from tokens where expiry < $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // ValidToken checks that a given token is valid; in order to be valid, the token must exist in the database, the associated user must exist in the database, and the token must not have expired.
 //
 // Parameter:
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestTouch checks that using a token is recorded in a single update, which also slides
+// the expiry when there is an idle timeout.
+func TestTouch(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+
+	models := New(mockDB)
+	token := Token{ID: 42}
+
+	mock.ExpectExec(`update tokens set last_used_at = \$1 where id = \$2`).
+		WithArgs(sqlmock.AnyArg(), 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`update tokens set last_used_at = \$1, updated_at = \$1,\s+expiry = least\(greatest\(expiry, \$2\), created_at \+ make_interval\(secs => \$3\)\)\s+where id = \$4`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), (15 * time.Minute).Seconds(), 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = models.Token.Touch(token, 0, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("Touch without an idle timeout: %v", err)
+	}
+
+	err = models.Token.Touch(token, 5*time.Minute, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("Touch with an idle timeout: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"html"
+	"strings"
+)
+
+// Search kinds, reported in SearchResult.Kind.
+const (
+	SearchKindBook   = "book"
+	SearchKindAuthor = "author"
+)
+
+// the delimiters ts_headline wraps matches in; they cannot appear in ordinary text, so the
+// snippet can be HTML escaped before they are swapped for <mark> tags
+const (
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+// headlineOptions are the ts_headline options shared by every search query.
+const headlineOptions = `StartSel=` + headlineStart + `, StopSel=` + headlineStop +
+	`, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=" … "`
+
+// SearchResult is one match from a search: either a book or an author.
+type SearchResult struct {
+	// Kind is SearchKindBook or SearchKindAuthor.
+	Kind string `json:"kind"`
+	// ID is the primary key of the book or author.
+	ID int `json:"id"`
+	// Title is the title of the book, or the name of the author.
+	Title string `json:"title"`
+	// Slug is the slug of the book; it is empty for authors.
+	Slug string `json:"slug,omitempty"`
+	// Snippet is an HTML escaped extract of the matching text, with the matching words
+	// wrapped in <mark> tags.
+	Snippet string `json:"snippet"`
+	// Rank is the relevance of the match; results are sorted by it, highest first.
+	Rank float64 `json:"rank"`
+}
+
+// Search is the data model for searching the book catalog.
+type Search struct {
+	// primary sends this model's reads to the primary database; see Models.Primary.
+	primary bool
+}
+
+// Query runs a full text search over book titles and descriptions and author names, and
+// returns books and authors together, ranked by relevance. The query accepts web search
+// syntax: quoted phrases, "or", and "-" to exclude a word. If nothing matches and fuzzy
+// is true, titles and names are instead matched by trigram similarity, which tolerates
+// typos; this requires the pg_trgm extension.
+//
+// Parameters:
+// - q: string: the search query
+// - limit: int: the maximum number of results to return
+// - fuzzy: bool: whether to fall back to trigram matching
+//
+// Returns:
+// - []*SearchResult: the results, best match first
+// - bool: true if the results came from the trigram fallback
+// - error: an error
+func (s *Search) Query(q string, limit int, fuzzy bool) ([]*SearchResult, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		with query as (
+			select websearch_to_tsquery('english', $1) as english, websearch_to_tsquery('simple', $1) as simple
+		)
+		select 'book', b.id, coalesce(b.title, ''), coalesce(b.slug, ''),
+			ts_headline('english', coalesce(b.title, '') || ': ' || coalesce(b.description, ''), query.english, $3),
+			ts_rank(b.search_vector, query.english)
+		from books b, query
+		where b.search_vector @@ query.english
+		union all
+		select 'author', a.id, coalesce(a.author_name, ''), '',
+			ts_headline('simple', coalesce(a.author_name, ''), query.simple, $3),
+			ts_rank(a.search_vector, query.simple)
+		from authors a, query
+		where a.search_vector @@ query.simple
+		order by 6 desc, 2
+		limit $2`
+
+	results, err := runSearch(ctx, readDB(s.primary), query, q, limit, headlineOptions)
+	if err != nil || len(results) > 0 || !fuzzy {
+		return results, false, err
+	}
+
+	query = `
+		select 'book', id, coalesce(title, ''), coalesce(slug, ''), coalesce(title, ''), similarity(title, $1)
+		from books
+		where title % $1
+		union all
+		select 'author', id, coalesce(author_name, ''), '', coalesce(author_name, ''), similarity(author_name, $1)
+		from authors
+		where author_name % $1
+		order by 6 desc, 2
+		limit $2`
+
+	results, err = runSearch(ctx, readDB(s.primary), query, q, limit)
+
+	return results, true, err
+}
+
+// TrigramAvailable reports whether the pg_trgm extension, which the fuzzy fallback of
+// Query needs, is installed. Migrations install it only if the database role may.
+//
+// Returns:
+// - bool: true if pg_trgm is installed
+// - error: an error
+func (s *Search) TrigramAvailable() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var available bool
+	err := db.QueryRowContext(ctx, `select exists (select 1 from pg_extension where extname = 'pg_trgm')`).Scan(&available)
+
+	return available, err
+}
+
+// runSearch runs one of the search queries and reads its results, turning the raw
+// headline into a safe HTML snippet.
+func runSearch(ctx context.Context, pool *sql.DB, query string, args ...any) ([]*SearchResult, error) {
+	rows, err := pool.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+
+	for rows.Next() {
+		var result SearchResult
+		err := rows.Scan(
+			&result.Kind,
+			&result.ID,
+			&result.Title,
+			&result.Slug,
+			&result.Snippet,
+			&result.Rank,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Snippet = strings.NewReplacer(headlineStart, "<mark>", headlineStop, "</mark>").
+			Replace(html.EscapeString(result.Snippet))
+
+		results = append(results, &result)
+	}
+
+	return results, rows.Err()
+}
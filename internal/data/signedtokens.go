@@ -0,0 +1,282 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidResetToken is returned for a password reset token that is malformed, forged,
+// expired, or already used.
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// ErrInvalidVerificationToken is returned for an email verification token that is
+// malformed, forged, expired, or already used.
+var ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+
+// The purposes signed tokens are issued for. The signature covers the purpose, so that a
+// token issued for one cannot be used for another.
+const (
+	purposePasswordReset = "password-reset"
+	purposeVerifyEmail   = "verify-email"
+)
+
+// PasswordResetToken returns a token that lets the user set a new password without
+// knowing the current one. The token names the user and an expiry time, and is signed
+// with the secret. The signature also covers the user's password hash, so the token
+// stops working as soon as the password changes: it can be used only once, and any
+// older tokens are invalidated along with it. Nothing is stored in the database.
+//
+// Parameters:
+//
+// - secret: []byte: the key used to sign the token
+// - ttl: time.Duration: how long the token is valid for
+//
+// Returns:
+//
+// - string: the token, safe to use in a URL
+func (u *User) PasswordResetToken(secret []byte, ttl time.Duration) string {
+	return u.signedToken(secret, purposePasswordReset, ttl)
+}
+
+// GetForPasswordReset returns the user a password reset token was issued to, after
+// checking its signature and expiry against the user's current password hash. The user is
+// always read from the primary database, so a password that was just changed is seen.
+//
+// Parameters:
+//
+// - token: string: the token, as returned by PasswordResetToken
+// - secret: []byte: the key the token was signed with
+//
+// Returns:
+//
+// - *User: a pointer to the User model
+// - error: ErrInvalidResetToken if the token is not valid, or another error
+func (u *User) GetForPasswordReset(token string, secret []byte) (*User, error) {
+	return getForSignedToken(token, secret, purposePasswordReset, ErrInvalidResetToken)
+}
+
+// ResetPasswordWithToken sets a new password for a user returned by GetForPasswordReset,
+// but only if the password hash is still the one the token was checked against. Of two
+// requests carrying the same token, only one can succeed, which makes the token single
+// use even when both pass GetForPasswordReset at once.
+//
+// Parameters:
+//
+// - password: string: the new password for the user
+//
+// Returns:
+//
+// - error: ErrInvalidResetToken if the password has changed since, or another error
+func (u *User) ResetPasswordWithToken(password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set password = $1, updated_at = $2 where id = $3 and password = $4`
+	result, err := db.ExecContext(ctx, stmt, hashedPassword, time.Now(), u.ID, u.Password)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrInvalidResetToken
+	}
+
+	u.Password = string(hashedPassword)
+
+	return nil
+}
+
+// VerificationToken returns a token that proves the user received an email sent to their
+// address. It works like PasswordResetToken, but the signature covers the user's email
+// address and whether it has been verified, so the token can be used only once, and not
+// at all after the address changes.
+//
+// Parameters:
+//
+// - secret: []byte: the key used to sign the token
+// - ttl: time.Duration: how long the token is valid for
+//
+// Returns:
+//
+// - string: the token, safe to use in a URL
+func (u *User) VerificationToken(secret []byte, ttl time.Duration) string {
+	return u.signedToken(secret, purposeVerifyEmail, ttl)
+}
+
+// GetForVerification returns the user an email verification token was issued to, after
+// checking its signature and expiry. The user is always read from the primary database.
+//
+// Parameters:
+//
+// - token: string: the token, as returned by VerificationToken
+// - secret: []byte: the key the token was signed with
+//
+// Returns:
+//
+// - *User: a pointer to the User model
+// - error: ErrInvalidVerificationToken if the token is not valid, or another error
+func (u *User) GetForVerification(token string, secret []byte) (*User, error) {
+	return getForSignedToken(token, secret, purposeVerifyEmail, ErrInvalidVerificationToken)
+}
+
+// Verify records that the user has verified their email address.
+//
+// Parameters:
+//
+// - none
+//
+// Returns:
+//
+// - error: an error
+func (u *User) Verify() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+	stmt := `update users set verified_at = $1, updated_at = $1 where id = $2 and verified_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, now, u.ID)
+	if err != nil {
+		return err
+	}
+
+	u.VerifiedAt = &now
+
+	return nil
+}
+
+// ReserveVerificationEmail records that a verification email is about to be sent to the
+// user, unless the user is already verified, or was sent one less than interval ago. It
+// is what limits how often verification emails can be requested.
+//
+// Parameters:
+//
+// - interval: time.Duration: the shortest time allowed between two verification emails
+//
+// Returns:
+//
+// - bool: true if the email should be sent
+// - error: an error
+func (u *User) ReserveVerificationEmail(interval time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set verification_sent_at = now()
+		where id = $1 and verified_at is null
+		and (verification_sent_at is null or verification_sent_at <= now() - make_interval(secs => $2))
+		returning id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt, u.ID, interval.Seconds()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// signedToken returns a token for purpose, naming the user and an expiry time, signed
+// together with the part of the user's state the purpose binds it to.
+func (u *User) signedToken(secret []byte, purpose string, ttl time.Duration) string {
+	payload := strconv.Itoa(u.ID) + "." + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(u.tokenSignature(secret, purpose, payload))
+}
+
+// getForSignedToken checks a token made by signedToken and returns the user it names, or
+// invalid if the token is malformed, expired, or its signature does not match.
+func getForSignedToken(token string, secret []byte, purpose string, invalid error) (*User, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, invalid
+	}
+
+	rawPayload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, invalid
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, invalid
+	}
+
+	payload := string(rawPayload)
+
+	id, expiry, ok := strings.Cut(payload, ".")
+	if !ok {
+		return nil, invalid
+	}
+
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, invalid
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return nil, invalid
+	}
+
+	primary := User{primary: true}
+
+	user, err := primary.GetOne(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, invalid
+		}
+		return nil, err
+	}
+
+	if !hmac.Equal(signature, user.tokenSignature(secret, purpose, payload)) {
+		return nil, invalid
+	}
+
+	return user, nil
+}
+
+// tokenSignature signs a token's purpose and payload together with the user's state.
+func (u *User) tokenSignature(secret []byte, purpose, payload string) []byte {
+	var state string
+
+	switch purpose {
+	case purposePasswordReset:
+		state = u.Password
+	case purposeVerifyEmail:
+		state = u.Email
+		if u.VerifiedAt != nil {
+			state += "\x00verified"
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	for _, part := range []string{purpose, payload, state} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+
+	return mac.Sum(nil)
+}
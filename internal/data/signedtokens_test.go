@@ -0,0 +1,67 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestPasswordResetTokenIsSingleUse checks that of two requests carrying the same reset
+// token, both of which pass the signature check before either writes, only the first
+// sets a password.
+func TestPasswordResetTokenIsSingleUse(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockDB.Close()
+
+	models := New(mockDB)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	oldHash := "$2a$12$oldhasholdhasholdhasholdhasholdhasholdhasholdhashold"
+
+	owner := User{ID: 7, Email: "reader@example.com", Password: oldHash}
+	token := owner.PasswordResetToken(secret, time.Hour)
+
+	userRow := func() *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "password", "is_admin", "verified_at", "created_at", "updated_at"}).
+			AddRow(7, "reader@example.com", "Ada", "Reader", oldHash, false, now, now, now)
+	}
+
+	// both requests read the user before either has changed the password
+	mock.ExpectQuery(`from users where id = \$1`).WithArgs(7).WillReturnRows(userRow())
+	mock.ExpectQuery(`from users where id = \$1`).WithArgs(7).WillReturnRows(userRow())
+	mock.ExpectExec(`update users set password = \$1, updated_at = \$2 where id = \$3 and password = \$4`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 7, oldHash).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`update users set password = \$1, updated_at = \$2 where id = \$3 and password = \$4`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 7, oldHash).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	first, err := models.User.GetForPasswordReset(token, secret)
+	if err != nil {
+		t.Fatalf("first GetForPasswordReset: %v", err)
+	}
+
+	second, err := models.User.GetForPasswordReset(token, secret)
+	if err != nil {
+		t.Fatalf("second GetForPasswordReset: %v", err)
+	}
+
+	err = first.ResetPasswordWithToken("correct horse battery")
+	if err != nil {
+		t.Fatalf("first ResetPasswordWithToken: %v", err)
+	}
+
+	err = second.ResetPasswordWithToken("another password entirely")
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("second ResetPasswordWithToken: error = %v; want ErrInvalidResetToken", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
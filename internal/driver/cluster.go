@@ -0,0 +1,182 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Cluster is a primary database and any number of read replicas of it. Replicas are
+// health checked in the background, and reads fail over to the primary whenever none of
+// them is healthy.
+type Cluster struct {
+	// Primary is the connection to the primary database, which takes every write.
+	Primary *DB
+
+	replicas  []*replica
+	next      atomic.Uint64
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// replica is one read replica, and whether its last health check passed.
+type replica struct {
+	db      *DB
+	name    string
+	healthy atomic.Bool
+}
+
+// ConnectCluster connects to a primary database, retrying as ConnectPostgres does, and
+// opens a pool for each replica. Unlike the primary, a replica that cannot be reached is
+// not an error: it is marked unhealthy and used once a health check finds it back up.
+//
+// Parameters:
+//   - primaryDSN: The DSN of the primary database.
+//   - replicaDSNs: The DSNs of the read replicas; there may be none.
+//   - opts: Options for every pool, including the health check interval.
+//
+// Returns:
+//   - The cluster, or an error if the primary could not be reached or a DSN is invalid.
+func ConnectCluster(primaryDSN string, replicaDSNs []string, opts ...Option) (*Cluster, error) {
+	o := defaultOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	primary, err := ConnectPostgres(primaryDSN, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{Primary: primary, stop: make(chan struct{})}
+
+	for i, dsn := range replicaDSNs {
+		d, connConfig, err := open(dsn, o)
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+
+		r := &replica{db: &DB{SQL: d}, name: fmt.Sprintf("%s:%d", connConfig.Host, connConfig.Port)}
+		// assume the best, so that the first health check logs a replica that is down
+		r.healthy.Store(true)
+		c.replicas = append(c.replicas, r)
+	}
+
+	if len(c.replicas) > 0 {
+		c.checkReplicas(o.healthCheckInterval)
+
+		c.wg.Add(1)
+		go c.monitor(o.healthCheckInterval)
+	}
+
+	return c, nil
+}
+
+// Reader returns the pool that reads should use: the next healthy replica in turn, or the
+// primary if there are no healthy replicas.
+//
+// Returns:
+//   - The pool to read from.
+func (c *Cluster) Reader() *sql.DB {
+	n := uint64(len(c.replicas))
+
+	for i := uint64(0); i < n; i++ {
+		r := c.replicas[(c.next.Add(1)-1)%n]
+		if r.healthy.Load() {
+			return r.db.SQL
+		}
+	}
+
+	return c.Primary.SQL
+}
+
+// Close stops the health checks and closes every pool. It is safe to call more than
+// once; later calls return the result of the first.
+//
+// Returns:
+//   - An error if any pool failed to close.
+func (c *Cluster) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.wg.Wait()
+
+		errs := []error{c.Primary.SQL.Close()}
+		for _, r := range c.replicas {
+			errs = append(errs, r.db.SQL.Close())
+		}
+
+		c.closeErr = errors.Join(errs...)
+	})
+
+	return c.closeErr
+}
+
+// Publish exports the statistics of every pool, along with the health of each replica, as
+// an expvar variable with the given name. Like expvar.Publish, it panics if the name is
+// already in use.
+//
+// Parameters:
+//   - name: The name of the expvar variable.
+func (c *Cluster) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		type replicaStats struct {
+			sql.DBStats
+			Name    string
+			Healthy bool
+		}
+
+		replicas := make([]replicaStats, 0, len(c.replicas))
+		for _, r := range c.replicas {
+			replicas = append(replicas, replicaStats{DBStats: r.db.Stats(), Name: r.name, Healthy: r.healthy.Load()})
+		}
+
+		return map[string]any{"primary": c.Primary.Stats(), "replicas": replicas}
+	}))
+}
+
+// monitor checks the replicas' health every interval until the cluster is closed.
+func (c *Cluster) monitor(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.checkReplicas(interval)
+		}
+	}
+}
+
+// checkReplicas pings every replica, and logs any that has gone down or come back up.
+func (c *Cluster) checkReplicas(timeout time.Duration) {
+	for _, r := range c.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := r.db.SQL.PingContext(ctx)
+		cancel()
+
+		healthy := err == nil
+		if r.healthy.Swap(healthy) == healthy {
+			continue
+		}
+
+		if healthy {
+			log.Infof("Replica %s is healthy; sending reads to it", r.name)
+		} else {
+			log.Warnf("Replica %s is down: %v; reads fail over to the other replicas or the primary", r.name, err)
+		}
+	}
+}
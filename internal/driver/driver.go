@@ -1,12 +1,17 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
+	"expvar"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
 	"time"
 
 	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v4"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,51 +21,212 @@ type DB struct {
 	SQL *sql.DB
 }
 
-var dbConn = &DB{}
+// options holds the settings applied to a connection pool. The zero value of each field
+// leaves database/sql's own default in place.
+type options struct {
+	maxOpenConns        int
+	maxIdleConns        int
+	connMaxLifetime     time.Duration
+	connMaxIdleTime     time.Duration
+	statementTimeout    time.Duration
+	connectTimeout      time.Duration
+	backoff             time.Duration
+	maxBackoff          time.Duration
+	healthCheckInterval time.Duration
+}
+
+// defaultOptions are the settings used unless an Option overrides them.
+var defaultOptions = options{
+	maxOpenConns:        5,
+	maxIdleConns:        5,
+	connMaxLifetime:     5 * time.Minute,
+	backoff:             500 * time.Millisecond,
+	maxBackoff:          10 * time.Second,
+	healthCheckInterval: 5 * time.Second,
+}
+
+// Option changes one setting of the connection pool opened by ConnectPostgres.
+type Option func(*options)
+
+// WithMaxOpenConns sets the most connections the pool may have open at once.
+func WithMaxOpenConns(n int) Option {
+	return func(o *options) { o.maxOpenConns = n }
+}
+
+// WithMaxIdleConns sets the most idle connections the pool keeps.
+func WithMaxIdleConns(n int) Option {
+	return func(o *options) { o.maxIdleConns = n }
+}
+
+// WithConnMaxLifetime sets how long a connection may be reused before it is replaced;
+// zero means forever.
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(o *options) { o.connMaxLifetime = d }
+}
 
-const maxOpenDbConn = 5
-const maxIdleDbConn = 5
-const maxDbLifeTime = 5 * time.Minute
+// WithConnMaxIdleTime sets how long a connection may sit idle before it is closed; zero
+// means forever.
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(o *options) { o.connMaxIdleTime = d }
+}
 
-// ConnectPostgres connects to the Postgres database and returns a connection to the database
+// WithStatementTimeout makes the server cancel any statement that runs for longer than
+// d; zero means no limit.
+func WithStatementTimeout(d time.Duration) Option {
+	return func(o *options) { o.statementTimeout = d }
+}
+
+// WithConnectRetry makes ConnectPostgres keep trying to reach the database until timeout
+// has passed, so that the database may start after the program does. The wait between
+// attempts starts at backoff and doubles after each failure, up to maxBackoff, with up to
+// half of each wait chosen at random so that many instances do not retry in lock step. A
+// zero timeout means a single attempt.
+func WithConnectRetry(timeout, backoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.connectTimeout = timeout
+		o.backoff = backoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithHealthCheckInterval sets how often a Cluster checks the health of its replicas.
+func WithHealthCheckInterval(d time.Duration) Option {
+	return func(o *options) { o.healthCheckInterval = d }
+}
+
+// ConnectPostgres connects to the Postgres database and returns a connection to the database.
+// Each call opens a new, independent pool.
 //
 // Parameters:
 //   - dsn: The DSN (Data Source Name) of the database to connect to.
+//   - opts: Options that change the pool's settings from the defaults.
 //
 // Returns:
 //   - A connection to the database, or an error if the connection fails.
-func ConnectPostgres(dsn string) (*DB, error) {
-	d, err := sql.Open("pgx", dsn)
+func ConnectPostgres(dsn string, opts ...Option) (*DB, error) {
+	o := defaultOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	d, _, err := open(dsn, o)
 	if err != nil {
 		return nil, err
 	}
 
-	d.SetMaxOpenConns(maxOpenDbConn)
-	d.SetMaxIdleConns(maxIdleDbConn)
-	d.SetConnMaxLifetime(maxDbLifeTime)
+	err = connectWithRetry(d, o)
+	if err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+
+	return &DB{SQL: d}, nil
+}
 
-	err = testDB(d)
+// open creates a pool with the given options, without connecting to the database.
+func open(dsn string, o options) (*sql.DB, *pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if err == nil {
-		dbConn.SQL = d
+	if o.statementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(o.statementTimeout.Milliseconds(), 10)
 	}
-	return dbConn, err
+
+	d := stdlib.OpenDB(*connConfig)
+
+	d.SetMaxOpenConns(o.maxOpenConns)
+	d.SetMaxIdleConns(o.maxIdleConns)
+	d.SetConnMaxLifetime(o.connMaxLifetime)
+	d.SetConnMaxIdleTime(o.connMaxIdleTime)
+
+	return d, connConfig, nil
+}
+
+// connectWithRetry pings the database until it answers or the connect timeout passes,
+// logging each attempt and waiting with exponential backoff and jitter in between.
+func connectWithRetry(d *sql.DB, o options) error {
+	start := time.Now()
+	deadline := start.Add(o.connectTimeout)
+	backoff := o.backoff
+
+	for attempt := 1; ; attempt++ {
+		err := testDB(d, deadline)
+		if err == nil {
+			if attempt > 1 {
+				log.Infof("Connected to database after %d attempts in %s", attempt, time.Since(start).Round(time.Millisecond))
+			}
+			return nil
+		}
+
+		wait := jitter(backoff)
+		if remaining := time.Until(deadline); wait > remaining {
+			wait = remaining
+		}
+		if wait <= 0 {
+			return fmt.Errorf("gave up after %d attempts in %s: %w", attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+
+		log.Warnf("Database not ready (attempt %d): %v; retrying in %s", attempt, err, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+
+		backoff = min(backoff*2, o.maxBackoff)
+	}
+}
+
+// jitter returns a random duration between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+
+	return d/2 + rand.N(d/2)
 }
 
-// testDB tests the connection to the database and logs a message if the connection is successful
+// Stats returns the connection pool's statistics.
+//
+// Returns:
+//   - The statistics of the pool.
+func (db *DB) Stats() sql.DBStats {
+	return db.SQL.Stats()
+}
+
+// Publish exports the pool's statistics as an expvar variable with the given name, so
+// they are served, as JSON, by expvar.Handler. Like expvar.Publish, it panics if the name
+// is already in use.
+//
+// Parameters:
+//   - name: The name of the expvar variable.
+func (db *DB) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return db.Stats()
+	}))
+}
+
+// testDB tests the connection to the database and logs a message if the connection is successful.
+// Failures are left for the caller to log.
 //
 // Parameters:
 //   - d: The database connection to test.
+//   - deadline: The time by which the test must finish; a single attempt is always given
+//     at least a few seconds.
 //
 // Returns:
 //   - An error if the connection test fails.
-func testDB(d *sql.DB) error {
-	err := d.Ping()
+func testDB(d *sql.DB, deadline time.Time) error {
+	if minDeadline := time.Now().Add(5 * time.Second); deadline.Before(minDeadline) {
+		deadline = minDeadline
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	err := d.PingContext(ctx)
 	if err != nil {
-		log.Errorf("Error while pinging database: %v", err)
-		return err
-	} else {
-		log.Infof("*** Pinged database successfully! ***")
+		return fmt.Errorf("pinging database: %w", err)
 	}
+
+	log.Infof("*** Pinged database successfully! ***")
 	return nil
 }
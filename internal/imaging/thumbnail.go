@@ -0,0 +1,65 @@
+// Package imaging resizes images using only the standard library.
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// Thumbnail scales img down so that it fits within maxWidth by maxHeight, preserving its
+// aspect ratio. Images that already fit are returned unchanged. Each output pixel is the
+// average of the source pixels it covers, which avoids the aliasing of nearest neighbour
+// sampling when shrinking large covers.
+//
+// Parameters:
+//   - img: The image to scale.
+//   - maxWidth: The maximum width of the result, in pixels.
+//   - maxHeight: The maximum height of the result, in pixels.
+//
+// Returns:
+//   - The scaled image.
+func Thumbnail(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	if srcW <= maxWidth && srcH <= maxHeight {
+		return img
+	}
+
+	// pick the scale that makes the limiting dimension fit exactly
+	dstW, dstH := maxWidth, srcH*maxWidth/srcW
+	if dstH > maxHeight {
+		dstW, dstH = srcW*maxHeight/srcH, maxHeight
+	}
+	dstW, dstH = max(dstW, 1), max(dstH, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := max(bounds.Min.Y+(y+1)*srcH/dstH, y0+1)
+
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := max(bounds.Min.X+(x+1)*srcW/dstW, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import "sync"
+
+// Fake is a Mailer that keeps messages in memory instead of sending them, for tests. The
+// zero value is ready to use.
+type Fake struct {
+	mu   sync.Mutex
+	sent []*Message
+}
+
+// Send renders the message, exactly as a real Mailer would, and records it.
+func (f *Fake) Send(recipient, templateFile string, data any) error {
+	msg, err := Render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sent = append(f.sent, msg)
+
+	return nil
+}
+
+// Sent returns every message sent so far, oldest first.
+func (f *Fake) Sent() []*Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*Message(nil), f.sent...)
+}
@@ -0,0 +1,78 @@
+// Package mailer sends templated email through an SMTP server, such as the mailhog
+// service that docker-compose runs for development. Messages are rendered from the
+// templates embedded in the binary, and Queue delivers them in the background from an
+// outbox table, retrying failures, so that mail survives restarts. Code that sends mail
+// should depend on the Mailer interface, so that tests can use a Fake.
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+// Mailer sends email rendered from a template.
+type Mailer interface {
+	// Send renders the named template with data and sends the result to recipient. It
+	// returns once the message has been accepted for delivery, which may be before it
+	// has been delivered.
+	Send(recipient, templateFile string, data any) error
+}
+
+// Message is a rendered email.
+type Message struct {
+	// To is the address the message is sent to.
+	To string
+	// Subject is the subject line.
+	Subject string
+	// PlainBody is the plain text version of the body.
+	PlainBody string
+	// HTMLBody is the HTML version of the body.
+	HTMLBody string
+}
+
+// Render renders an embedded template into a message. Each template file must define
+// three templates: "subject", "plainBody" and "htmlBody". The HTML body is rendered with
+// html/template, so data is escaped; the others are rendered as plain text.
+//
+// Parameters:
+//   - recipient: The address the message is for.
+//   - templateFile: The name of the template file, such as "password_reset.tmpl".
+//   - data: The data to render the templates with.
+//
+// Returns:
+//   - The rendered message, or an error if the template is missing or fails.
+func Render(recipient, templateFile string, data any) (*Message, error) {
+	text, err := template.New("").ParseFS(templates, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := htmltemplate.New("").ParseFS(templates, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var subject, plainBody, htmlBody bytes.Buffer
+
+	if err = text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err = text.ExecuteTemplate(&plainBody, "plainBody", data); err != nil {
+		return nil, err
+	}
+	if err = html.ExecuteTemplate(&htmlBody, "htmlBody", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:        recipient,
+		Subject:   string(bytes.TrimSpace(subject.Bytes())),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
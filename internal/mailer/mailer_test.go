@@ -0,0 +1,85 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// TestRenderEscapesHTMLOnly checks that data is escaped in the HTML body, but left alone
+// in the subject and plain text body.
+func TestRenderEscapesHTMLOnly(t *testing.T) {
+	msg, err := Render("reader@example.com", "password_reset.tmpl", map[string]any{
+		"FirstName": "<Ann>",
+		"Link":      "http://localhost:8080/reset-password?token=abc",
+		"TTL":       "1h0m0s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Subject != "Reset your password" {
+		t.Errorf("subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.PlainBody, "Hi <Ann>,") {
+		t.Errorf("plain body does not greet <Ann> verbatim:\n%s", msg.PlainBody)
+	}
+	if !strings.Contains(msg.HTMLBody, "Hi &lt;Ann&gt;,") || strings.Contains(msg.HTMLBody, "<Ann>") {
+		t.Errorf("HTML body does not escape <Ann>:\n%s", msg.HTMLBody)
+	}
+}
+
+// TestBuildMultipart checks that a message is formatted with plain text and HTML parts
+// that mail clients can decode.
+func TestBuildMultipart(t *testing.T) {
+	s, err := NewSMTP("localhost", 1025, "", "", "Library <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{To: "reader@example.com", Subject: "Héllo", PlainBody: "plain = text", HTMLBody: "<p>html</p>"}
+
+	raw, err := s.build(&mail.Address{Address: msg.To}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Héllo" {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type = %q, %v", mediaType, err)
+	}
+
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if part.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Errorf("part %q = %q; want %q %q", part.Header.Get("Content-Type"), body, want.contentType, want.body)
+		}
+	}
+}
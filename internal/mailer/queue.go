@@ -0,0 +1,279 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often idle workers look for mail that is due for another attempt.
+const pollInterval = 5 * time.Second
+
+// cleanupInterval is how often dead messages are looked for; see cleanUp.
+const cleanupInterval = time.Hour
+
+// deadRetention is how long a message that used up every attempt is kept, without its
+// body, before it is deleted.
+const deadRetention = 7 * 24 * time.Hour
+
+// Transport delivers a rendered message; SMTP is the usual one.
+type Transport interface {
+	Deliver(msg *Message) error
+}
+
+// Queue is a Mailer that stores each message in the mail_outbox table, and delivers it
+// from there with a pool of workers. A message that fails is retried with exponential
+// backoff, from ten seconds up to an hour, until it has been tried maxAttempts times.
+// Since the outbox is in the database, mail that has not been delivered when the program
+// stops is delivered after it starts again, by this or any other instance.
+//
+// Messages often carry links that work as credentials, such as password reset links, so
+// a message is deleted once delivered, and one that used up every attempt loses its body
+// at once and is deleted after a week; only the recipient, subject and last error stay
+// for inspection until then.
+type Queue struct {
+	db          *sql.DB
+	transport   Transport
+	workers     int
+	maxAttempts int
+	wake        chan struct{}
+	stop        chan struct{}
+	wg          sync.WaitGroup
+	// Logf, if set, is called to report failed deliveries.
+	Logf func(format string, args ...any)
+}
+
+// NewQueue returns a queue that delivers mail with transport. Call Start to start its
+// workers.
+//
+// Parameters:
+//   - db: The database holding the mail_outbox table.
+//   - transport: Delivers each message.
+//   - workers: The number of messages delivered at once.
+//   - maxAttempts: The number of times a message is tried before giving up on it.
+//
+// Returns:
+//   - The queue.
+func NewQueue(db *sql.DB, transport Transport, workers, maxAttempts int) *Queue {
+	return &Queue{
+		db:          db,
+		transport:   transport,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Send renders a message and stores it in the outbox, to be delivered by a worker.
+//
+// Parameters:
+//   - recipient: The address to send the message to.
+//   - templateFile: The name of the template file to render.
+//   - data: The data to render the template with.
+//
+// Returns:
+//   - An error if the message could not be rendered or stored.
+func (q *Queue) Send(recipient, templateFile string, data any) error {
+	msg, err := Render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = q.db.ExecContext(ctx,
+		`insert into mail_outbox (recipient, subject, plain_body, html_body) values ($1, $2, $3, $4)`,
+		msg.To, msg.Subject, msg.PlainBody, msg.HTMLBody)
+	if err != nil {
+		return err
+	}
+
+	// wake an idle worker, unless one is already due to wake
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Start starts the workers, and a goroutine that cleans up dead messages.
+func (q *Queue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	q.wg.Add(1)
+	go q.cleanUpEvery(cleanupInterval)
+}
+
+// Stop stops the workers, waiting for those delivering a message to finish, or for ctx
+// to be done. Messages still in the outbox stay there until the queue next starts.
+//
+// Parameters:
+//   - ctx: Bounds how long to wait for the workers.
+//
+// Returns:
+//   - ctx.Err() if the workers did not finish in time.
+func (q *Queue) Stop(ctx context.Context) error {
+	close(q.stop)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work delivers messages until the queue is stopped, sleeping whenever none is due.
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		delivered, err := q.deliverNext()
+		if err != nil {
+			q.logf("mailer: reading outbox: %v", err)
+		}
+		if delivered {
+			continue
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// deliverNext claims the next message that is due and tries to deliver it. Claiming a
+// message counts the attempt and schedules the next one, so a message being delivered is
+// not picked up by another worker, and is retried later if this one dies part way.
+//
+// Returns:
+//   - Whether a message was found, whether or not it was delivered.
+//   - An error if the outbox could not be read or updated.
+func (q *Queue) deliverNext() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update mail_outbox set
+			attempts = attempts + 1,
+			next_attempt_at = now() + least(interval '10 seconds' * power(2, attempts), interval '1 hour')
+		where id = (
+			select id from mail_outbox
+			where attempts < $1 and next_attempt_at <= now()
+			order by next_attempt_at, id
+			for update skip locked
+			limit 1
+		)
+		returning id, recipient, subject, plain_body, html_body, attempts`
+
+	var id int64
+	var attempts int
+	var msg Message
+
+	err := q.db.QueryRowContext(ctx, query, q.maxAttempts).Scan(&id, &msg.To, &msg.Subject, &msg.PlainBody, &msg.HTMLBody, &attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	deliveryErr := q.transport.Deliver(&msg)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if deliveryErr == nil {
+		_, err = q.db.ExecContext(ctx, `delete from mail_outbox where id = $1`, id)
+		return true, err
+	}
+
+	if attempts >= q.maxAttempts {
+		q.logf("mailer: giving up on message %d to %s after %d attempts: %v", id, msg.To, attempts, deliveryErr)
+	} else {
+		q.logf("mailer: delivering message %d to %s (attempt %d of %d): %v", id, msg.To, attempts, q.maxAttempts, deliveryErr)
+	}
+
+	_, err = q.db.ExecContext(ctx, `update mail_outbox set last_error = $1,
+			plain_body = case when attempts >= $2 then '' else plain_body end,
+			html_body = case when attempts >= $2 then '' else html_body end
+		where id = $3`, deliveryErr.Error(), q.maxAttempts, id)
+
+	return true, err
+}
+
+// cleanUpEvery calls cleanUp now and then every interval, until the queue is stopped.
+func (q *Queue) cleanUpEvery(interval time.Duration) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := q.cleanUp()
+		if err != nil {
+			q.logf("mailer: cleaning up outbox: %v", err)
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanUp removes the bodies of dead messages, those that used up every attempt, which a
+// worker that died part way through its last attempt did not get to, and deletes dead
+// messages older than deadRetention.
+//
+// Returns:
+//   - An error if the outbox could not be updated.
+func (q *Queue) cleanUp() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// a claimed message is not dead until its attempt is over, and its next attempt
+	// would have been due
+	_, err := q.db.ExecContext(ctx, `update mail_outbox set plain_body = '', html_body = ''
+		where attempts >= $1 and next_attempt_at <= now() and (plain_body <> '' or html_body <> '')`,
+		q.maxAttempts)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.db.ExecContext(ctx, `delete from mail_outbox
+		where attempts >= $1 and created_at < now() - make_interval(secs => $2)`,
+		q.maxAttempts, deadRetention.Seconds())
+
+	return err
+}
+
+// logf calls Logf if it is set.
+func (q *Queue) logf(format string, args ...any) {
+	if q.Logf != nil {
+		q.Logf(format, args...)
+	}
+}
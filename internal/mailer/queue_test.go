@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// failingTransport is a Transport that never delivers anything.
+type failingTransport struct{}
+
+func (failingTransport) Deliver(*Message) error { return errors.New("connection refused") }
+
+// TestDeadMessagesLoseTheirBodies checks that a message whose last attempt fails has its
+// body removed at once, and that cleaning up removes what is left of dead messages.
+func TestDeadMessagesLoseTheirBodies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	q := NewQueue(db, failingTransport{}, 1, 3)
+
+	mock.ExpectQuery(`update mail_outbox set\s+attempts = attempts \+ 1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "recipient", "subject", "plain_body", "html_body", "attempts"}).
+			AddRow(9, "reader@example.com", "Reset your password", "token=abc", "token=abc", 3))
+	mock.ExpectExec(`update mail_outbox set last_error = \$1,\s+plain_body = case when attempts >= \$2 then '' else plain_body end,\s+html_body = case when attempts >= \$2 then '' else html_body end\s+where id = \$3`).
+		WithArgs("connection refused", 3, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	found, err := q.deliverNext()
+	if err != nil || !found {
+		t.Fatalf("deliverNext = %v, %v; want true, nil", found, err)
+	}
+
+	mock.ExpectExec(`update mail_outbox set plain_body = '', html_body = ''\s+where attempts >= \$1 and next_attempt_at <= now\(\)`).
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`delete from mail_outbox\s+where attempts >= \$1 and created_at < now\(\) - make_interval\(secs => \$2\)`).
+		WithArgs(3, deadRetention.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	err = q.cleanUp()
+	if err != nil {
+		t.Fatalf("cleanUp: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// Timeouts for talking to the SMTP server: for connecting, and for the whole exchange.
+const (
+	dialTimeout = 10 * time.Second
+	sendTimeout = 30 * time.Second
+)
+
+// SMTP delivers messages to an SMTP server.
+type SMTP struct {
+	host   string
+	addr   string
+	auth   smtp.Auth
+	sender *mail.Address
+}
+
+// NewSMTP returns an SMTP transport for the server at host and port. Authentication is
+// only used if username is set.
+//
+// Parameters:
+//   - host: The host name of the SMTP server.
+//   - port: The port of the SMTP server.
+//   - username: The user name to authenticate with, or "" for none.
+//   - password: The password to authenticate with.
+//   - sender: The From address, such as "Library <no-reply@example.com>".
+//
+// Returns:
+//   - The transport, or an error if the sender is not a valid address.
+func NewSMTP(host string, port int, username, password, sender string) (*SMTP, error) {
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: invalid sender %q: %w", sender, err)
+	}
+
+	s := &SMTP{
+		host:   host,
+		addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+		sender: from,
+	}
+
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return s, nil
+}
+
+// Deliver sends a message, as a multipart message with plain text and HTML parts.
+//
+// Parameters:
+//   - msg: The message to send.
+//
+// Returns:
+//   - An error if the message could not be handed to the SMTP server.
+func (s *SMTP) Deliver(msg *Message) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("mailer: invalid recipient %q: %w", msg.To, err)
+	}
+
+	body, err := s.build(to, msg)
+	if err != nil {
+		return err
+	}
+
+	return s.send(to.Address, body)
+}
+
+// send hands a formatted message to the server. It does what smtp.SendMail does, but
+// with timeouts, so that a server that stops responding cannot hold up a worker forever.
+func (s *SMTP) send(recipient string, body []byte) error {
+	conn, err := net.DialTimeout("tcp", s.addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(sendTimeout))
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+
+	if s.auth != nil {
+		if err = c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(s.sender.Address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(recipient); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(body); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// build formats a message as a multipart/alternative MIME message, with the plain text
+// part first so that clients which understand HTML prefer it.
+func (s *SMTP) build(to *mail.Address, msg *Message) ([]byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(b)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.sender)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n", boundary)
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.PlainBody},
+		{"text/html", msg.HTMLBody},
+	} {
+		fmt.Fprintf(&buf, "\r\n--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(&buf, "\r\n--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
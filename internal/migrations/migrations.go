@@ -0,0 +1,343 @@
+// Package migrations applies the versioned SQL migrations embedded in the binary. Each
+// migration is a pair of files in the sql directory, NNNN_name.up.sql and
+// NNNN_name.down.sql, where NNNN is its version. Applied versions are recorded in the
+// schema_migrations table, and a Postgres advisory lock ensures that only one migrator
+// runs at a time, so several API instances may start together safely.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the key of the advisory lock held while migrating. It is an arbitrary
+// constant that only needs to be unique among the advisory locks this database uses.
+const lockID = 7_318_224_061
+
+// Migration is one versioned change to the schema.
+type Migration struct {
+	// Version orders the migrations; it is the numeric prefix of the file names.
+	Version int
+	// Name describes the migration; it is the rest of the file names.
+	Name string
+	// Up is the SQL that applies the migration.
+	Up string
+	// Down is the SQL that reverts the migration.
+	Down string
+}
+
+// Status describes whether a migration has been applied.
+type Status struct {
+	// Version is the version of the migration.
+	Version int `json:"version"`
+	// Name is the name of the migration.
+	Name string `json:"name"`
+	// AppliedAt is the time the migration was applied, or nil if it is pending.
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	// Logf, if set, is called to report each migration as it is applied or reverted.
+	Logf func(format string, args ...any)
+}
+
+// New returns a Migrator for the migrations embedded in the binary.
+//
+// Parameters:
+//   - db: The database to migrate.
+//
+// Returns:
+//   - The migrator, or an error if the embedded migrations are malformed.
+func New(db *sql.DB) (*Migrator, error) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Load reads every migration from the top level of fsys, sorted by version. Every
+// version must have both an up and a down file, no version may appear twice, and the
+// versions must run from 1 without gaps, so that a migration lost in a merge is noticed.
+//
+// Parameters:
+//   - fsys: The file system holding the migration files.
+//
+// Returns:
+//   - The migrations, or an error if any file is misnamed or missing its pair, or a
+//     version is missing.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, name := range names {
+		base := strings.TrimSuffix(name, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+
+		prefix, label, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version < 1 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("migrations: badly named file %q", name)
+		}
+
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if m.Name != label {
+			return nil, fmt.Errorf("migrations: version %d is used by both %q and %q", version, m.Name, label)
+		}
+
+		if direction == ".up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: version %d (%s) needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrations: version %d is missing", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Up applies every pending migration, in order, and returns those it applied. Each
+// migration runs in its own transaction, so a failure leaves the earlier ones applied.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - The migrations that were applied.
+//   - An error if any migration failed.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			m.logf("applying migration %04d_%s", migration.Version, migration.Name)
+
+			err = inTx(ctx, conn, migration.Up,
+				`insert into schema_migrations (version, name) values ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the most recently applied migrations, newest first, and returns those
+// it reverted.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - steps: The number of migrations to revert.
+//
+// Returns:
+//   - The migrations that were reverted.
+//   - An error if any migration failed.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+
+			m.logf("reverting migration %04d_%s", migration.Version, migration.Name)
+
+			err = inTx(ctx, conn, migration.Down,
+				`delete from schema_migrations where version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status reports, for every known migration, whether and when it was applied.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - The status of each migration, oldest first.
+//   - An error if the applied versions could not be read.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := Status{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := done[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// logf calls Logf if it is set.
+func (m *Migrator) logf(format string, args ...any) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+// withLock runs fn on a dedicated connection while holding the migration advisory lock,
+// after making sure the schema_migrations table exists. Advisory locks belong to a
+// session, which is why everything must happen on the one connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// use a fresh context, so the lock is released even if ctx has been cancelled
+		_, unlockErr := conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockID)
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `create table if not exists schema_migrations (
+		version bigint primary key,
+		name text not null,
+		applied_at timestamp without time zone not null default now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions returns the versions recorded in schema_migrations, with the time
+// each was applied.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+
+	return done, rows.Err()
+}
+
+// inTx runs a migration's SQL and the statement that records it in one transaction.
+func inTx(ctx context.Context, conn *sql.Conn, migration, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, migration)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
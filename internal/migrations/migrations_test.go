@@ -0,0 +1,138 @@
+package migrations
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestLoad checks that migration files are paired up and ordered by version, and that
+// misnamed, unpaired, duplicated and missing versions are rejected.
+func TestLoad(t *testing.T) {
+	file := func(contents string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(contents)} }
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name: "paired and ordered",
+			fsys: fstest.MapFS{
+				"0002_add_books.up.sql":   file("create table books ();"),
+				"0002_add_books.down.sql": file("drop table books;"),
+				"0001_add_users.up.sql":   file("create table users ();"),
+				"0001_add_users.down.sql": file("drop table users;"),
+				"README.md":               file("not a migration"),
+				"archive/0003_old.up.sql": file("ignored"),
+			},
+			want: []Migration{
+				{Version: 1, Name: "add_users", Up: "create table users ();", Down: "drop table users;"},
+				{Version: 2, Name: "add_books", Up: "create table books ();", Down: "drop table books;"},
+			},
+		},
+		{
+			name: "empty",
+			fsys: fstest.MapFS{},
+			want: []Migration{},
+		},
+		{
+			name: "missing down file",
+			fsys: fstest.MapFS{
+				"0001_add_users.up.sql": file("create table users ();"),
+			},
+			wantErr: "needs both an up and a down file",
+		},
+		{
+			name: "version used twice",
+			fsys: fstest.MapFS{
+				"0001_add_users.up.sql":   file("create table users ();"),
+				"0001_add_users.down.sql": file("drop table users;"),
+				"0001_add_books.up.sql":   file("create table books ();"),
+				"0001_add_books.down.sql": file("drop table books;"),
+			},
+			wantErr: "version 1 is used by both",
+		},
+		{
+			name: "gap in versions",
+			fsys: fstest.MapFS{
+				"0001_add_users.up.sql":   file("create table users ();"),
+				"0001_add_users.down.sql": file("drop table users;"),
+				"0003_add_books.up.sql":   file("create table books ();"),
+				"0003_add_books.down.sql": file("drop table books;"),
+			},
+			wantErr: "version 2 is missing",
+		},
+		{
+			name: "not starting at 1",
+			fsys: fstest.MapFS{
+				"0002_add_books.up.sql":   file("create table books ();"),
+				"0002_add_books.down.sql": file("drop table books;"),
+			},
+			wantErr: "version 1 is missing",
+		},
+		{
+			name:    "version is not a number",
+			fsys:    fstest.MapFS{"first_add_users.up.sql": file("")},
+			wantErr: "badly named file",
+		},
+		{
+			name:    "version zero",
+			fsys:    fstest.MapFS{"0000_add_users.up.sql": file("")},
+			wantErr: "badly named file",
+		},
+		{
+			name:    "no name",
+			fsys:    fstest.MapFS{"0001.up.sql": file("")},
+			wantErr: "badly named file",
+		},
+		{
+			name:    "no direction",
+			fsys:    fstest.MapFS{"0001_add_users.sql": file("")},
+			wantErr: "badly named file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v; want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("Load returned %d migrations; want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("migration %d = %+v; want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestEmbeddedMigrations checks that the migrations shipped in the binary load.
+func TestEmbeddedMigrations(t *testing.T) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := Load(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+}
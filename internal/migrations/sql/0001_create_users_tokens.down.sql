@@ -0,0 +1,2 @@
+drop table if exists tokens;
+drop table if exists users;
@@ -0,0 +1,23 @@
+-- The users and tokens tables, as first used by the API. Later migrations
+-- evolve them; "if not exists" lets this run against databases created by hand
+-- before migrations existed.
+create table if not exists users (
+    id integer generated always as identity primary key,
+    email character varying(255) not null unique,
+    first_name character varying(255) not null default '',
+    last_name character varying(255) not null default '',
+    password character varying(60) not null,
+    created_at timestamp without time zone not null default now(),
+    updated_at timestamp without time zone not null default now()
+);
+
+create table if not exists tokens (
+    id integer generated always as identity primary key,
+    user_id integer not null references users (id) on update cascade on delete cascade,
+    email character varying(255) not null,
+    token character varying(255) not null,
+    token_hash bytea not null,
+    created_at timestamp without time zone not null default now(),
+    updated_at timestamp without time zone not null default now(),
+    expiry timestamp without time zone not null
+);
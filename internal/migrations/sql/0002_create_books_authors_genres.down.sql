@@ -0,0 +1,4 @@
+drop table if exists books_genres;
+drop table if exists books;
+drop table if exists genres;
+drop table if exists authors;
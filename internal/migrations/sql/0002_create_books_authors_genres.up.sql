@@ -0,0 +1,33 @@
+-- The book catalog schema from books-authors-genres.sql.
+create table if not exists authors (
+    id integer generated always as identity primary key,
+    author_name character varying(512),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+create table if not exists genres (
+    id integer generated always as identity primary key,
+    genre_name character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+create table if not exists books (
+    id integer generated always as identity primary key,
+    title character varying(512),
+    author_id integer references authors (id) on update cascade on delete cascade,
+    publication_year integer,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    slug character varying(512),
+    description text
+);
+
+create table if not exists books_genres (
+    id integer generated always as identity primary key,
+    book_id integer references books (id) on update cascade on delete cascade,
+    genre_id integer references genres (id) on update cascade on delete cascade,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
@@ -0,0 +1,5 @@
+-- The plain text tokens cannot be recovered from their hashes; the column is
+-- restored empty, and existing sessions keep working through token_hash.
+drop index if exists tokens_token_hash_idx;
+
+alter table tokens add column if not exists token character varying(255);
@@ -0,0 +1,19 @@
+-- Tokens are looked up by the SHA-256 hash of the plain text token, so the
+-- plain text column is no longer needed. Backfill the hash for any row that
+-- is missing one before dropping the column, so existing sessions survive.
+do $$
+begin
+    if exists (
+        select 1 from information_schema.columns
+        where table_schema = current_schema() and table_name = 'tokens' and column_name = 'token'
+    ) then
+        update tokens
+        set token_hash = sha256(convert_to(token, 'UTF8'))
+        where token_hash is null;
+
+        alter table tokens drop column token;
+    end if;
+end
+$$;
+
+create unique index if not exists tokens_token_hash_idx on tokens (token_hash);
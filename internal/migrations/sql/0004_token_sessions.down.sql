@@ -0,0 +1,7 @@
+drop index if exists tokens_user_id_created_at_idx;
+
+alter table tokens
+    drop column if exists user_agent,
+    drop column if exists ip_address,
+    drop column if exists device_label,
+    drop column if exists last_used_at;
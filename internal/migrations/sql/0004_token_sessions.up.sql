@@ -0,0 +1,9 @@
+-- A user may now hold several sessions at once; record enough about each one
+-- to let them tell their devices apart.
+alter table tokens
+    add column if not exists user_agent text not null default '',
+    add column if not exists ip_address character varying(45) not null default '',
+    add column if not exists device_label character varying(255) not null default '',
+    add column if not exists last_used_at timestamp without time zone not null default now();
+
+create index if not exists tokens_user_id_created_at_idx on tokens (user_id, created_at);
@@ -0,0 +1,8 @@
+delete from tokens where scope <> 'authentication';
+
+drop index if exists tokens_family_id_idx;
+
+alter table tokens
+    drop column if exists scope,
+    drop column if exists family_id,
+    drop column if exists rotated_at;
@@ -0,0 +1,14 @@
+-- Logins now issue a short-lived authentication token and a rotating refresh
+-- token. Tokens descending from the same login share a family, so reuse of a
+-- rotated refresh token can revoke the whole session at once.
+alter table tokens
+    add column if not exists scope character varying(32) not null default 'authentication',
+    add column if not exists family_id character varying(26),
+    add column if not exists rotated_at timestamp without time zone;
+
+-- every existing token was issued by its own login
+update tokens set family_id = id::text where family_id is null;
+
+alter table tokens alter column family_id set not null;
+
+create index if not exists tokens_family_id_idx on tokens (family_id);
@@ -0,0 +1,2 @@
+-- Administrators may manage every user; everyone else may only manage themselves.
+alter table users add column if not exists is_admin boolean not null default false;
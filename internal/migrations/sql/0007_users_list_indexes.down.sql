@@ -0,0 +1,4 @@
+drop index if exists users_last_name_id_idx;
+drop index if exists users_first_name_id_idx;
+drop index if exists users_email_id_idx;
+drop index if exists users_created_at_id_idx;
@@ -0,0 +1,6 @@
+-- Support keyset pagination over each sortable column of the user list; the
+-- id tie-breaker keeps the order stable when values repeat.
+create index if not exists users_last_name_id_idx on users (last_name, id);
+create index if not exists users_first_name_id_idx on users (first_name, id);
+create index if not exists users_email_id_idx on users (email, id);
+create index if not exists users_created_at_id_idx on users (created_at, id);
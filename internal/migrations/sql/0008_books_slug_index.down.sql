@@ -0,0 +1,3 @@
+drop index if exists books_slug_idx;
+drop index if exists books_genres_book_id_idx;
+drop index if exists books_genres_genre_id_idx;
@@ -0,0 +1,5 @@
+-- Books are fetched by slug, which must therefore be unique.
+create unique index if not exists books_slug_idx on books (slug);
+
+create index if not exists books_genres_book_id_idx on books_genres (book_id);
+create index if not exists books_genres_genre_id_idx on books_genres (genre_id);
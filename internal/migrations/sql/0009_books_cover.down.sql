@@ -0,0 +1 @@
+alter table books drop column if exists cover_path;
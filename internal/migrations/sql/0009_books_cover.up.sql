@@ -0,0 +1,2 @@
+-- The storage key of each book's cover image; the thumbnail sits alongside it.
+alter table books add column if not exists cover_path character varying(512);
@@ -0,0 +1,5 @@
+drop index if exists authors_search_vector_idx;
+alter table authors drop column if exists search_vector;
+
+drop index if exists books_search_vector_idx;
+alter table books drop column if exists search_vector;
@@ -0,0 +1,15 @@
+-- Full text search over book titles (weighted highest), descriptions and
+-- author names. The vectors are generated columns, so they never go stale.
+alter table books add column if not exists search_vector tsvector
+    generated always as (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) stored;
+
+create index if not exists books_search_vector_idx on books using gin (search_vector);
+
+-- names are not English prose, so they are not stemmed
+alter table authors add column if not exists search_vector tsvector
+    generated always as (to_tsvector('simple', coalesce(author_name, ''))) stored;
+
+create index if not exists authors_search_vector_idx on authors using gin (search_vector);
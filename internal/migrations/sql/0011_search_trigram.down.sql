@@ -0,0 +1,4 @@
+drop index if exists authors_author_name_trgm_idx;
+drop index if exists books_title_trgm_idx;
+
+-- the extension is left installed, since other objects may have come to depend on it
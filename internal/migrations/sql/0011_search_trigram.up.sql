@@ -0,0 +1,21 @@
+-- Trigram indexes back the typo tolerant search fallback, which is only used
+-- when the API is started with fuzzy search enabled. The pg_trgm extension is
+-- optional: a role that may not create it, or a server without it, skips the
+-- fallback's indexes rather than failing this migration and every later one.
+do $$
+begin
+    create extension if not exists pg_trgm;
+exception
+    when insufficient_privilege or undefined_file or feature_not_supported then
+        raise notice 'pg_trgm is not available, so fuzzy search cannot be enabled: %', sqlerrm;
+end
+$$;
+
+do $$
+begin
+    if exists (select 1 from pg_extension where extname = 'pg_trgm') then
+        create index if not exists books_title_trgm_idx on books using gin (title gin_trgm_ops);
+        create index if not exists authors_author_name_trgm_idx on authors using gin (author_name gin_trgm_ops);
+    end if;
+end
+$$;
@@ -0,0 +1,16 @@
+-- Outgoing email waits here until it has been delivered, so that it survives restarts.
+-- Rows are deleted once sent. Rows that used up every attempt lose their bodies, which may
+-- hold links that work as credentials, and are deleted after a week.
+create table if not exists mail_outbox (
+    id bigserial primary key,
+    recipient text not null,
+    subject text not null,
+    plain_body text not null,
+    html_body text not null,
+    attempts integer not null default 0,
+    next_attempt_at timestamp without time zone not null default now(),
+    last_error text,
+    created_at timestamp without time zone not null default now()
+);
+
+create index if not exists mail_outbox_next_attempt_at_idx on mail_outbox (next_attempt_at);
@@ -0,0 +1,2 @@
+alter table users drop column if exists verification_sent_at;
+alter table users drop column if exists verified_at;
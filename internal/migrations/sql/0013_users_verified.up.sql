@@ -0,0 +1,7 @@
+-- When each user proved they own their email address, and when they were last sent a
+-- verification email, which limits how often one can be requested. Existing users were
+-- created before verification existed, so they are treated as verified.
+alter table users add column if not exists verified_at timestamp without time zone;
+alter table users add column if not exists verification_sent_at timestamp without time zone;
+
+update users set verified_at = coalesce(created_at, now()) where verified_at is null;
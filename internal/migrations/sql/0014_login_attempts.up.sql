@@ -0,0 +1,19 @@
+-- Every failed sign in is recorded here, as an audit trail and to count recent failures
+-- per account and per client address. Attempts are counted by email address, whether or
+-- not it belongs to a user, so that unknown addresses are throttled like real ones.
+-- Clearing an account's failures, on a successful sign in or an administrator's unlock,
+-- marks its rows cleared instead of deleting them, so the audit trail stays complete.
+create table if not exists login_attempts (
+    id bigserial primary key,
+    email character varying(255) not null,
+    user_id integer references users (id) on delete set null,
+    ip_address character varying(45) not null,
+    user_agent text not null default '',
+    reason character varying(32) not null,
+    cleared boolean not null default false,
+    created_at timestamp without time zone not null default now()
+);
+
+create index if not exists login_attempts_email_idx on login_attempts (lower(email), created_at);
+create index if not exists login_attempts_ip_address_idx on login_attempts (ip_address, created_at);
+create index if not exists login_attempts_user_id_idx on login_attempts (user_id, created_at);
@@ -0,0 +1,7 @@
+-- Email addresses are compared ignoring case, so two accounts must not differ only by
+-- case. Addresses were once stored as typed; they are folded to lower case here, as they
+-- are now on the way in. If two existing accounts clash, this fails, and they have to be
+-- merged or renamed by hand before migrating.
+update users set email = lower(email) where email <> lower(email);
+
+create unique index if not exists users_email_lower_idx on users (lower(email));
@@ -0,0 +1,3 @@
+-- The columns keep timestamp without time zone, which is what 0012 to 0014 now create, so
+-- there is nothing to revert.
+select 1;
@@ -0,0 +1,18 @@
+-- Every timestamp column is timestamp without time zone, as in the first migrations.
+-- Databases that applied earlier versions of 0012 to 0014, or created schema_migrations,
+-- with timestamp with time zone columns are brought into line here; the values are
+-- converted in the session's time zone, which is what now() defaults use elsewhere. On
+-- columns that already have the right type, these statements change nothing.
+alter table mail_outbox
+    alter column next_attempt_at type timestamp without time zone,
+    alter column created_at type timestamp without time zone;
+
+alter table users
+    alter column verified_at type timestamp without time zone,
+    alter column verification_sent_at type timestamp without time zone;
+
+alter table login_attempts
+    alter column created_at type timestamp without time zone;
+
+alter table schema_migrations
+    alter column applied_at type timestamp without time zone;
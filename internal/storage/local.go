@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local is a Storage that keeps objects as files under a directory on the local disk.
+type Local struct {
+	// Dir is the directory objects are stored under.
+	Dir string
+}
+
+// NewLocal returns a Local store rooted at dir, creating the directory if needed.
+//
+// Parameters:
+//   - dir: The directory to store objects under.
+//
+// Returns:
+//   - The store, or an error if the directory could not be created.
+func NewLocal(dir string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{Dir: dir}, nil
+}
+
+// filename maps a key to a path under Dir, refusing keys that would escape it.
+func (l *Local) filename(key string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" || cleaned != key {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(l.Dir, filepath.FromSlash(cleaned)), nil
+}
+
+// Put writes the contents of r to key. The data is written to a temporary file first and
+// renamed into place, so a reader never sees a partially written object.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	name, err := l.filename(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// Open returns the object stored at key. Its content type is derived from the key's
+// file extension.
+func (l *Local) Open(ctx context.Context, key string) (*Object, error) {
+	name, err := l.filename(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+
+	return &Object{
+		ReadSeekCloser: f,
+		ContentType:    mime.TypeByExtension(path.Ext(key)),
+		Size:           info.Size(),
+		ModTime:        info.ModTime(),
+	}, nil
+}
+
+// Delete removes the object stored at key.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.filename(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	return err
+}
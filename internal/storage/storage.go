@@ -0,0 +1,39 @@
+// Package storage provides a place to keep uploaded files, such as book covers. The
+// API depends only on the Storage interface, so that the local disk implementation
+// used today can be swapped for an S3 compatible one later.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Open and Delete when no object exists at a key.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned when a key is empty or tries to escape the store.
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Object is an open, readable object returned by Open. The caller must close it.
+type Object struct {
+	io.ReadSeekCloser
+	// ContentType is the MIME type of the object.
+	ContentType string
+	// Size is the size of the object in bytes.
+	Size int64
+	// ModTime is the time the object was last written.
+	ModTime time.Time
+}
+
+// Storage is a flat store of objects addressed by slash separated keys, such as
+// "covers/12/abc.jpg".
+type Storage interface {
+	// Put writes the contents of r to key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open returns the object stored at key.
+	Open(ctx context.Context, key string) (*Object, error)
+	// Delete removes the object stored at key.
+	Delete(ctx context.Context, key string) error
+}